@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AuditEntry is one link of the append-only audit chain. Hash covers every
+// other field including PrevHash, so changing or removing any entry breaks
+// the chain from that point on.
+type AuditEntry struct {
+	ID         uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Actor      string    `json:"actor"`
+	Operation  string    `json:"operation"`
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"entity_id"`
+	Before     string    `json:"before,omitempty"`
+	After      string    `json:"after,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// AuditVerification is the result of walking the audit chain.
+type AuditVerification struct {
+	Valid         bool   `json:"valid"`
+	Entries       int    `json:"entries"`
+	FirstBrokenID uint64 `json:"first_broken_id,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// auditActorSystem is recorded for changes not made on behalf of a user.
+const auditActorSystem = "system"
+
+// computeHash hashes the entry's content together with the previous hash.
+func (e *AuditEntry) computeHash() string {
+	// Encode as a JSON array so field boundaries are unambiguous
+	content, _ := json.Marshal([]string{
+		e.PrevHash,
+		e.Timestamp.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.Operation,
+		e.EntityType,
+		e.EntityID,
+		e.Before,
+		e.After,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditSnapshot encodes an entity for the audit log, redacting secrets.
+func auditSnapshot(entity interface{}) (string, error) {
+	if entity == nil {
+		return "", nil
+	}
+
+	switch user := entity.(type) {
+	case User:
+		user.Password = "[redacted]"
+		entity = user
+	case *User:
+		redacted := *user
+		redacted.Password = "[redacted]"
+		entity = redacted
+	}
+
+	snapshot, err := json.Marshal(entity)
+	if err != nil {
+		return "", err
+	}
+	return string(snapshot), nil
+}
+
+// recordAudit appends an entry describing a state change to the audit chain.
+// before is nil for creations.
+func recordAudit(store Store, actor, operation, entityType, entityID string, before, after interface{}) error {
+	entry := AuditEntry{
+		Timestamp:  time.Now().UTC().Truncate(time.Microsecond),
+		Actor:      actor,
+		Operation:  operation,
+		EntityType: entityType,
+		EntityID:   entityID,
+	}
+
+	var err error
+	if entry.Before, err = auditSnapshot(before); err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+	if entry.After, err = auditSnapshot(after); err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+
+	// Link to the most recent entry. The transaction serializes appends so
+	// that no two entries link to the same predecessor.
+	return store.Transaction(func(tx Store) error {
+		last, err := tx.LastAuditEntry()
+		switch {
+		case err == nil:
+			entry.PrevHash = last.Hash
+		case !errors.Is(err, ErrNotFound):
+			return fmt.Errorf("failed to fetch last audit entry: %w", err)
+		}
+
+		entry.Hash = entry.computeHash()
+		if err := tx.AppendAuditEntry(&entry); err != nil {
+			return fmt.Errorf("failed to record audit entry: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListAuditEntriesRequest filters and pages audit entries by entity.
+type ListAuditEntriesRequest struct {
+	PageRequest
+	EntityType string `json:"entity_type"`
+	EntityID   string `json:"entity_id"`
+}
+
+func listAuditEntries(store Store, caller *User, input *ListAuditEntriesRequest) (*Page[AuditEntry], error) {
+	filter := AuditFilter{EntityType: input.EntityType, EntityID: input.EntityID}
+	entries, err := listPage(input.PageRequest, auditEntryList, func(opts ListOptions) ([]AuditEntry, error) {
+		filter.ListOptions = opts
+		return store.ListAuditEntries(filter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch audit entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// verifyAuditChain walks the whole chain in order and reports the first
+// entry whose hash or link to its predecessor does not match.
+func verifyAuditChain(store Store, caller *User, input *EmptyRequest) (*AuditVerification, error) {
+	verification := &AuditVerification{Valid: true}
+	prevHash := ""
+
+	err := store.WalkAuditEntries(func(entry *AuditEntry) error {
+		if !verification.Valid {
+			return nil
+		}
+		verification.Entries++
+
+		switch {
+		case entry.PrevHash != prevHash:
+			verification.Valid = false
+			verification.Reason = "entry does not link to the previous entry"
+		case entry.Hash != entry.computeHash():
+			verification.Valid = false
+			verification.Reason = "entry content does not match its hash"
+		}
+		if !verification.Valid {
+			verification.FirstBrokenID = entry.ID
+		}
+
+		prevHash = entry.Hash
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch audit entries: %w", err)
+	}
+
+	return verification, nil
+}
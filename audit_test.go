@@ -0,0 +1,110 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAuditChain(t *testing.T) {
+	tests := []struct {
+		name       string
+		tamper     func(entries []AuditEntry) []AuditEntry
+		wantValid  bool
+		wantBroken uint64
+		wantReason string
+	}{
+		{
+			name:      "intact chain",
+			tamper:    func(entries []AuditEntry) []AuditEntry { return entries },
+			wantValid: true,
+		},
+		{
+			name:      "empty chain",
+			tamper:    func(entries []AuditEntry) []AuditEntry { return nil },
+			wantValid: true,
+		},
+		{
+			name: "changed snapshot",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[2].After = `{"username":"mallory"}`
+				return entries
+			},
+			wantBroken: 3,
+			wantReason: "entry content does not match its hash",
+		},
+		{
+			name: "changed actor of first entry",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[0].Actor = "mallory"
+				return entries
+			},
+			wantBroken: 1,
+			wantReason: "entry content does not match its hash",
+		},
+		{
+			name: "changed and rehashed entry",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[2].Operation = "user.delete"
+				entries[2].Hash = entries[2].computeHash()
+				return entries
+			},
+			wantBroken: 4,
+			wantReason: "entry does not link to the previous entry",
+		},
+		{
+			name: "removed entry",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				return slices.Delete(entries, 2, 3)
+			},
+			wantBroken: 4,
+			wantReason: "entry does not link to the previous entry",
+		},
+		{
+			name: "swapped entries",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries
+			},
+			wantBroken: 3,
+			wantReason: "entry does not link to the previous entry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			for _, username := range []string{"alice", "bob", "carol", "dave", "erin"} {
+				user := User{Username: username, Password: "secret"}
+				if err := recordAudit(store, auditActorSystem, "user.create", "user", username, nil, user); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store.auditEntries = tt.tamper(store.auditEntries)
+
+			got, err := verifyAuditChain(store, nil, &EmptyRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Valid != tt.wantValid || got.FirstBrokenID != tt.wantBroken || got.Reason != tt.wantReason {
+				t.Errorf("verification = %+v, want valid %v, first broken %d, reason %q",
+					got, tt.wantValid, tt.wantBroken, tt.wantReason)
+			}
+			if got.Valid && got.Entries != len(store.auditEntries) {
+				t.Errorf("entries = %d, want %d", got.Entries, len(store.auditEntries))
+			}
+		})
+	}
+}
+
+func TestAuditSnapshotRedactsPasswords(t *testing.T) {
+	for _, entity := range []interface{}{User{Username: "alice", Password: "secret"}, &User{Username: "alice", Password: "secret"}} {
+		snapshot, err := auditSnapshot(entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `"password":"[redacted]"`; !strings.Contains(snapshot, want) {
+			t.Errorf("snapshot %s does not contain %s", snapshot, want)
+		}
+	}
+}
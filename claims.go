@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ClaimAction names a transition in the claim lifecycle.
+type ClaimAction string
+
+const (
+	ClaimActionReview               ClaimAction = "review"
+	ClaimActionConfirmTheft         ClaimAction = "confirm_theft"
+	ClaimActionDenyTheft            ClaimAction = "deny_theft"
+	ClaimActionApproveRepair        ClaimAction = "approve_repair"
+	ClaimActionApproveReimbursement ClaimAction = "approve_reimbursement"
+	ClaimActionReject               ClaimAction = "reject"
+	ClaimActionClose                ClaimAction = "close"
+	ClaimActionReopen               ClaimAction = "reopen"
+)
+
+// claimTransitionParams carries the values an actor supplies with a
+// transition.
+type claimTransitionParams struct {
+	Reimbursable  float32
+	FileReference string
+}
+
+// claimTransition is one legal edge of the claim state machine. Guard, if
+// set, can veto the transition for a particular claim; Effect applies any
+// side effects before the claim itself is saved.
+type claimTransition struct {
+	Action ClaimAction
+	From   []ClaimStatus
+	To     ClaimStatus
+	Roles  []Role
+	Guard  func(claim *Claim) error
+	Effect func(store Store, caller *User, claim *Claim, params claimTransitionParams) error
+}
+
+// claimTransitions is the complete claim lifecycle. Every status change goes
+// through applyClaimTransition, which only allows the edges listed here.
+var claimTransitions = []claimTransition{
+	{
+		Action: ClaimActionConfirmTheft,
+		From:   []ClaimStatus{ClaimStatusNew},
+		To:     ClaimStatusTheftConfirmed,
+		Roles:  []Role{RolePolice},
+		Guard:  requireTheft,
+		Effect: recordFileReference,
+	},
+	{
+		Action: ClaimActionDenyTheft,
+		From:   []ClaimStatus{ClaimStatusNew},
+		To:     ClaimStatusRejected,
+		Roles:  []Role{RolePolice},
+		Guard:  requireTheft,
+		Effect: func(store Store, caller *User, claim *Claim, params claimTransitionParams) error {
+			claim.Reimbursable = 0
+			return recordFileReference(store, caller, claim, params)
+		},
+	},
+	{
+		Action: ClaimActionReview,
+		From:   []ClaimStatus{ClaimStatusNew, ClaimStatusTheftConfirmed, ClaimStatusReopened},
+		To:     ClaimStatusUnderReview,
+		Roles:  []Role{RoleInsurer},
+		Guard:  requireTheftConfirmed,
+	},
+	{
+		Action: ClaimActionApproveRepair,
+		From:   []ClaimStatus{ClaimStatusNew, ClaimStatusUnderReview, ClaimStatusReopened},
+		To:     ClaimStatusRepair,
+		Roles:  []Role{RoleInsurer},
+		Guard: func(claim *Claim) error {
+			if claim.IsTheft {
+				return preconditionFailedError("theft_not_repairable", "cannot repair stolen items")
+			}
+			return nil
+		},
+		Effect: createRepairOrder,
+	},
+	{
+		Action: ClaimActionApproveReimbursement,
+		From:   []ClaimStatus{ClaimStatusNew, ClaimStatusUnderReview, ClaimStatusTheftConfirmed, ClaimStatusReopened},
+		To:     ClaimStatusReimbursement,
+		Roles:  []Role{RoleInsurer},
+		Guard:  requireTheftConfirmed,
+		Effect: reimburseClaim,
+	},
+	{
+		Action: ClaimActionReject,
+		From:   []ClaimStatus{ClaimStatusNew, ClaimStatusUnderReview, ClaimStatusTheftConfirmed, ClaimStatusReopened},
+		To:     ClaimStatusRejected,
+		Roles:  []Role{RoleInsurer},
+		Guard:  requireTheftConfirmed,
+		Effect: func(store Store, caller *User, claim *Claim, params claimTransitionParams) error {
+			claim.Reimbursable = 0
+			return nil
+		},
+	},
+	{
+		Action: ClaimActionClose,
+		From:   []ClaimStatus{ClaimStatusRepair, ClaimStatusReimbursement, ClaimStatusRejected},
+		To:     ClaimStatusClosed,
+		Roles:  []Role{RoleInsurer},
+		Guard: func(claim *Claim) error {
+			if claim.Status == ClaimStatusRepair && !claim.Repaired {
+				return preconditionFailedError("repair_not_completed", "repair has not been completed yet")
+			}
+			return nil
+		},
+	},
+	{
+		Action: ClaimActionReopen,
+		From:   []ClaimStatus{ClaimStatusRejected, ClaimStatusClosed},
+		To:     ClaimStatusReopened,
+		Roles:  []Role{RoleInsurer},
+	},
+}
+
+// ClaimNextAction describes a transition the caller may apply to a claim.
+type ClaimNextAction struct {
+	Action ClaimAction `json:"action"`
+	Status ClaimStatus `json:"status"`
+}
+
+// IsOpen reports whether a claim with this status is still being handled.
+func (s ClaimStatus) IsOpen() bool {
+	return s != ClaimStatusClosed && s != ClaimStatusRejected
+}
+
+func requireTheft(claim *Claim) error {
+	if !claim.IsTheft {
+		return preconditionFailedError("not_a_theft_claim", "claim is not related to theft")
+	}
+	return nil
+}
+
+func requireTheftConfirmed(claim *Claim) error {
+	if claim.IsTheft && claim.Status == ClaimStatusNew {
+		return preconditionFailedError("theft_not_confirmed", "theft must first be confirmed by authorities")
+	}
+	return nil
+}
+
+// claimIntakeRule is a condition a new claim must meet against its contract
+// and contract type. It returns the rejection if the claim breaks it.
+type claimIntakeRule func(claim *Claim, contract *Contract, contractType *ContractType) error
+
+// claimIntakeRules are checked in order by checkClaimIntake. Each rejects
+// with its own error code so the caller learns which rule failed.
+var claimIntakeRules = []claimIntakeRule{
+	requireContractNotVoid,
+	requireClaimInPeriod,
+	requireWaitingPeriodOver,
+	requireTheftCovered,
+}
+
+func requireContractNotVoid(claim *Claim, contract *Contract, contractType *ContractType) error {
+	if contract.Void {
+		return conflictError("contract_void", "contract %s is void", contract.UUID)
+	}
+	return nil
+}
+
+func requireClaimInPeriod(claim *Claim, contract *Contract, contractType *ContractType) error {
+	if claim.Date.Before(contract.StartDate) || claim.Date.After(contract.EndDate) {
+		return validationError("claim_outside_period", "claim date %s is outside the contract period %s to %s",
+			claim.Date.Format(time.DateOnly), contract.StartDate.Format(time.DateOnly), contract.EndDate.Format(time.DateOnly))
+	}
+	return nil
+}
+
+func requireWaitingPeriodOver(claim *Claim, contract *Contract, contractType *ContractType) error {
+	allowedFrom := contract.StartDate.AddDate(0, 0, int(contractType.WaitingPeriodDays))
+	if claim.Date.Before(allowedFrom) {
+		return validationError("waiting_period", "claims are only allowed from %s, after a waiting period of %d days",
+			allowedFrom.Format(time.DateOnly), contractType.WaitingPeriodDays)
+	}
+	return nil
+}
+
+func requireTheftCovered(claim *Claim, contract *Contract, contractType *ContractType) error {
+	if claim.IsTheft && !contractType.TheftInsured {
+		return validationError("theft_not_covered", "contract type %s does not cover theft", contractType.UUID)
+	}
+	return nil
+}
+
+// checkClaimIntake returns the first intake rule a new claim breaks.
+func checkClaimIntake(claim *Claim, contract *Contract, contractType *ContractType) error {
+	for _, rule := range claimIntakeRules {
+		if err := rule(claim, contract, contractType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordFileReference(store Store, caller *User, claim *Claim, params claimTransitionParams) error {
+	claim.FileReference = params.FileReference
+	return nil
+}
+
+func createRepairOrder(store Store, caller *User, claim *Claim, params claimTransitionParams) error {
+	claim.Reimbursable = 0
+
+	contract, err := claim.Contract(store)
+	if err != nil {
+		return fmt.Errorf("contract not found for UUID: %s", claim.ContractUUID)
+	}
+
+	// A claim has one repair order: approving a reopened claim for repair
+	// again reopens its order
+	existing, err := store.GetRepairOrderByClaimForUpdate(claim.UUID)
+	if err == nil {
+		before := *existing
+		existing.Item = contract.Item
+		existing.Ready = false
+		claim.Repaired = false
+		if err := store.SaveRepairOrder(existing); err != nil {
+			return fmt.Errorf("failed to reopen repair order: %w", err)
+		}
+		return recordAudit(store, caller.Username, "repair_order.reopen", "repair_order", existing.UUID, before, existing)
+	} else if !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to fetch repair order: %w", err)
+	}
+
+	repairOrder := RepairOrder{
+		UUID:         newUUID(),
+		Item:         contract.Item,
+		ClaimUUID:    claim.UUID,
+		ContractUUID: claim.ContractUUID,
+		Ready:        false,
+	}
+	if err := store.CreateRepairOrder(&repairOrder); err != nil {
+		return fmt.Errorf("failed to create repair order: %w", err)
+	}
+	return recordAudit(store, caller.Username, "repair_order.create", "repair_order", repairOrder.UUID, nil, repairOrder)
+}
+
+func reimburseClaim(store Store, caller *User, claim *Claim, params claimTransitionParams) error {
+	contract, err := claim.Contract(store)
+	if err != nil {
+		return fmt.Errorf("contract not found for UUID: %s", claim.ContractUUID)
+	}
+	contractType, err := store.GetContractType(contract.ContractTypeUUID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch contract type: %w", err)
+	}
+
+	// Never reimburse more than the item is worth or the contract insures
+	limit := contract.Item.Price
+	if contractType.MaxSumInsured > 0 && contractType.MaxSumInsured < limit {
+		limit = contractType.MaxSumInsured
+	}
+	if params.Reimbursable < 0 || params.Reimbursable > limit {
+		return validationError("reimbursable_out_of_range", "reimbursable amount %.2f must be between 0 and %.2f", params.Reimbursable, limit)
+	}
+	claim.Reimbursable = params.Reimbursable
+	if !claim.IsTheft {
+		return nil
+	}
+
+	// A stolen item cannot be insured any longer, so void its contract
+	before := *contract
+	contract.Void = true
+	if err := store.SaveContract(contract); err != nil {
+		return fmt.Errorf("failed to update contract: %w", err)
+	}
+	return recordAudit(store, caller.Username, "contract.void", "contract", contract.UUID, before, contract)
+}
+
+// findClaimTransition looks up a transition by action.
+func findClaimTransition(action ClaimAction) (*claimTransition, error) {
+	for i := range claimTransitions {
+		if claimTransitions[i].Action == action {
+			return &claimTransitions[i], nil
+		}
+	}
+	return nil, validationError("unknown_claim_action", "unknown claim action: %s", action)
+}
+
+// checkClaimTransition reports why the caller may not apply the transition
+// to the claim, or nil if it is allowed.
+func checkClaimTransition(t *claimTransition, caller *User, claim *Claim) error {
+	if !slices.Contains(t.From, claim.Status) {
+		return conflictError("invalid_claim_transition", "cannot %s a claim with status %s", t.Action, claim.Status)
+	}
+	if !slices.Contains(t.Roles, caller.Role) {
+		return forbiddenError("claim_action_forbidden", "role %s may not %s a claim", caller.Role, t.Action)
+	}
+	if t.Guard != nil {
+		return t.Guard(claim)
+	}
+	return nil
+}
+
+// applyClaimTransition is the single code path through which a claim's
+// status changes. It validates the transition, runs its side effects and
+// saves the claim.
+func applyClaimTransition(store Store, caller *User, claim *Claim, action ClaimAction, params claimTransitionParams) error {
+	t, err := findClaimTransition(action)
+	if err != nil {
+		return err
+	}
+	if err := checkClaimTransition(t, caller, claim); err != nil {
+		return err
+	}
+
+	before := *claim
+	if t.Effect != nil {
+		if err := t.Effect(store, caller, claim, params); err != nil {
+			return err
+		}
+	}
+
+	// Update the claim status
+	claim.Status = t.To
+	if err := store.SaveClaim(claim); err != nil {
+		return fmt.Errorf("failed to update claim: %w", err)
+	}
+
+	return recordAudit(store, caller.Username, "claim."+string(t.Action), "claim", claim.UUID, before, claim)
+}
+
+// claimActionForStatus finds the action that moves the claim to the
+// requested status for the caller's role, for clients that ask for a target
+// status rather than an action.
+func claimActionForStatus(caller *User, claim *Claim, status ClaimStatus) (ClaimAction, error) {
+	for i := range claimTransitions {
+		t := &claimTransitions[i]
+		if t.To == status && slices.Contains(t.From, claim.Status) && slices.Contains(t.Roles, caller.Role) {
+			return t.Action, nil
+		}
+	}
+	return "", conflictError("invalid_claim_transition", "cannot change claim status from %s to %s", claim.Status, status)
+}
+
+// nextClaimActions lists the transitions the caller may currently apply.
+func nextClaimActions(caller *User, claim *Claim) []ClaimNextAction {
+	actions := []ClaimNextAction{}
+	for i := range claimTransitions {
+		t := &claimTransitions[i]
+		if checkClaimTransition(t, caller, claim) == nil {
+			actions = append(actions, ClaimNextAction{Action: t.Action, Status: t.To})
+		}
+	}
+	return actions
+}
+
+// ClaimRequest identifies a claim.
+type ClaimRequest struct {
+	UUID string `json:"uuid" validate:"required"`
+}
+
+func listClaimActions(store Store, caller *User, input *ClaimRequest) ([]ClaimNextAction, error) {
+	// Fetch the claim
+	claim, err := store.GetClaim(input.UUID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("claim_not_found", "claim not found for UUID: %s", input.UUID)
+		}
+		return nil, fmt.Errorf("failed to fetch claim: %w", err)
+	}
+
+	return nextClaimActions(caller, claim), nil
+}
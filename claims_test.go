@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// errorCode returns the code of a domain error, or "" for any other error.
+func errorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// newTestClaim stores a customer, a contract type, a contract of the type
+// and the claim against it.
+func newTestClaim(t *testing.T, store Store, claim Claim) *Claim {
+	t.Helper()
+	now := time.Now().UTC()
+	if err := store.CreateUser(&User{Username: "alice", Password: "secret", Role: RoleCustomer}); err != nil {
+		t.Fatal(err)
+	}
+	contractType := ContractType{
+		UUID:          "type-1",
+		ShopType:      "phones",
+		FormulaPerDay: "price * 0.001",
+		MaxSumInsured: 1000,
+		TheftInsured:  true,
+		Active:        true,
+	}
+	if err := store.CreateContractType(&contractType); err != nil {
+		t.Fatal(err)
+	}
+	contract := Contract{
+		UUID:             "contract-1",
+		Number:           "POL-2026-000001",
+		Username:         "alice",
+		ContractTypeUUID: contractType.UUID,
+		Item:             Item{ID: 1, Brand: "Acme", Price: 500},
+		StartDate:        now.AddDate(0, -1, 0),
+		EndDate:          now.AddDate(1, 0, 0),
+	}
+	if err := store.CreateContract(&contract); err != nil {
+		t.Fatal(err)
+	}
+	claim.UUID = "claim-1"
+	claim.Number = "CLM-2026-000001"
+	claim.ContractUUID = contract.UUID
+	claim.FiledBy = contract.Username
+	claim.Date = now
+	if err := store.CreateClaim(&claim); err != nil {
+		t.Fatal(err)
+	}
+	return &claim
+}
+
+func TestApplyClaimTransition(t *testing.T) {
+	insurer := &User{Username: "insurer", Role: RoleInsurer}
+	police := &User{Username: "police", Role: RolePolice}
+	customer := &User{Username: "alice", Role: RoleCustomer}
+
+	tests := []struct {
+		name       string
+		claim      Claim
+		caller     *User
+		action     ClaimAction
+		params     claimTransitionParams
+		wantStatus ClaimStatus
+		wantCode   string
+	}{
+		{name: "review new claim", claim: Claim{Status: ClaimStatusNew}, caller: insurer, action: ClaimActionReview, wantStatus: ClaimStatusUnderReview},
+		{name: "review unconfirmed theft", claim: Claim{Status: ClaimStatusNew, IsTheft: true}, caller: insurer, action: ClaimActionReview, wantCode: "theft_not_confirmed"},
+		{name: "review confirmed theft", claim: Claim{Status: ClaimStatusTheftConfirmed, IsTheft: true}, caller: insurer, action: ClaimActionReview, wantStatus: ClaimStatusUnderReview},
+		{name: "customer may not review", claim: Claim{Status: ClaimStatusNew}, caller: customer, action: ClaimActionReview, wantCode: "claim_action_forbidden"},
+		{name: "police may not review", claim: Claim{Status: ClaimStatusNew}, caller: police, action: ClaimActionReview, wantCode: "claim_action_forbidden"},
+		{name: "police confirm theft", claim: Claim{Status: ClaimStatusNew, IsTheft: true}, caller: police, action: ClaimActionConfirmTheft, params: claimTransitionParams{FileReference: "F-1"}, wantStatus: ClaimStatusTheftConfirmed},
+		{name: "police deny theft", claim: Claim{Status: ClaimStatusNew, IsTheft: true, Reimbursable: 100}, caller: police, action: ClaimActionDenyTheft, wantStatus: ClaimStatusRejected},
+		{name: "confirm theft of damage claim", claim: Claim{Status: ClaimStatusNew}, caller: police, action: ClaimActionConfirmTheft, wantCode: "not_a_theft_claim"},
+		{name: "insurer may not confirm theft", claim: Claim{Status: ClaimStatusNew, IsTheft: true}, caller: insurer, action: ClaimActionConfirmTheft, wantCode: "claim_action_forbidden"},
+		{name: "confirm theft twice", claim: Claim{Status: ClaimStatusTheftConfirmed, IsTheft: true}, caller: police, action: ClaimActionConfirmTheft, wantCode: "invalid_claim_transition"},
+		{name: "approve repair", claim: Claim{Status: ClaimStatusUnderReview}, caller: insurer, action: ClaimActionApproveRepair, wantStatus: ClaimStatusRepair},
+		{name: "approve repair of theft", claim: Claim{Status: ClaimStatusUnderReview, IsTheft: true}, caller: insurer, action: ClaimActionApproveRepair, wantCode: "theft_not_repairable"},
+		{name: "approve reimbursement", claim: Claim{Status: ClaimStatusUnderReview}, caller: insurer, action: ClaimActionApproveReimbursement, params: claimTransitionParams{Reimbursable: 250}, wantStatus: ClaimStatusReimbursement},
+		{name: "approve reimbursement of confirmed theft", claim: Claim{Status: ClaimStatusTheftConfirmed, IsTheft: true}, caller: insurer, action: ClaimActionApproveReimbursement, params: claimTransitionParams{Reimbursable: 500}, wantStatus: ClaimStatusReimbursement},
+		{name: "reject", claim: Claim{Status: ClaimStatusReopened, Reimbursable: 100}, caller: insurer, action: ClaimActionReject, wantStatus: ClaimStatusRejected},
+		{name: "reject unconfirmed theft", claim: Claim{Status: ClaimStatusNew, IsTheft: true}, caller: insurer, action: ClaimActionReject, wantCode: "theft_not_confirmed"},
+		{name: "close unrepaired claim", claim: Claim{Status: ClaimStatusRepair}, caller: insurer, action: ClaimActionClose, wantCode: "repair_not_completed"},
+		{name: "close repaired claim", claim: Claim{Status: ClaimStatusRepair, Repaired: true}, caller: insurer, action: ClaimActionClose, wantStatus: ClaimStatusClosed},
+		{name: "close reimbursed claim", claim: Claim{Status: ClaimStatusReimbursement}, caller: insurer, action: ClaimActionClose, wantStatus: ClaimStatusClosed},
+		{name: "close claim under review", claim: Claim{Status: ClaimStatusUnderReview}, caller: insurer, action: ClaimActionClose, wantCode: "invalid_claim_transition"},
+		{name: "reopen closed claim", claim: Claim{Status: ClaimStatusClosed}, caller: insurer, action: ClaimActionReopen, wantStatus: ClaimStatusReopened},
+		{name: "reopen open claim", claim: Claim{Status: ClaimStatusUnderReview}, caller: insurer, action: ClaimActionReopen, wantCode: "invalid_claim_transition"},
+		{name: "unknown action", claim: Claim{Status: ClaimStatusNew}, caller: insurer, action: "escalate", wantCode: "unknown_claim_action"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			claim := newTestClaim(t, store, tt.claim)
+
+			err := applyClaimTransition(store, tt.caller, claim, tt.action, tt.params)
+			if tt.wantCode != "" {
+				if code := errorCode(err); code != tt.wantCode {
+					t.Fatalf("error = %v (code %q), want code %q", err, code, tt.wantCode)
+				}
+				stored, _ := store.GetClaim(claim.UUID)
+				if stored.Status != tt.claim.Status {
+					t.Errorf("stored status = %s, want it unchanged at %s", stored.Status, tt.claim.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyClaimTransition: %v", err)
+			}
+
+			stored, err := store.GetClaim(claim.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", stored.Status, tt.wantStatus)
+			}
+			if tt.params.FileReference != "" && stored.FileReference != tt.params.FileReference {
+				t.Errorf("file reference = %q, want %q", stored.FileReference, tt.params.FileReference)
+			}
+			if tt.wantStatus == ClaimStatusRejected && stored.Reimbursable != 0 {
+				t.Errorf("reimbursable = %v, want 0 for a rejected claim", stored.Reimbursable)
+			}
+			if tt.wantStatus == ClaimStatusReimbursement && stored.Reimbursable != tt.params.Reimbursable {
+				t.Errorf("reimbursable = %v, want %v", stored.Reimbursable, tt.params.Reimbursable)
+			}
+		})
+	}
+}
+
+func TestClaimTransitionEffects(t *testing.T) {
+	insurer := &User{Username: "insurer", Role: RoleInsurer}
+
+	t.Run("approve repair creates a repair order", func(t *testing.T) {
+		store := newMemoryStore()
+		claim := newTestClaim(t, store, Claim{Status: ClaimStatusNew})
+		if err := applyClaimTransition(store, insurer, claim, ClaimActionApproveRepair, claimTransitionParams{}); err != nil {
+			t.Fatal(err)
+		}
+		order, err := store.GetRepairOrderByClaim(claim.UUID)
+		if err != nil {
+			t.Fatalf("repair order: %v", err)
+		}
+		if order.Ready || order.Item.Brand != "Acme" {
+			t.Errorf("repair order = %+v, want an open order for the insured item", order)
+		}
+	})
+
+	t.Run("reimbursing a theft voids the contract", func(t *testing.T) {
+		store := newMemoryStore()
+		claim := newTestClaim(t, store, Claim{Status: ClaimStatusTheftConfirmed, IsTheft: true})
+		if err := applyClaimTransition(store, insurer, claim, ClaimActionApproveReimbursement, claimTransitionParams{Reimbursable: 500}); err != nil {
+			t.Fatal(err)
+		}
+		contract, err := store.GetContract(claim.ContractUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !contract.Void {
+			t.Error("contract is not void after reimbursing a theft")
+		}
+	})
+
+	t.Run("reimbursing damage keeps the contract", func(t *testing.T) {
+		store := newMemoryStore()
+		claim := newTestClaim(t, store, Claim{Status: ClaimStatusUnderReview})
+		if err := applyClaimTransition(store, insurer, claim, ClaimActionApproveReimbursement, claimTransitionParams{Reimbursable: 100}); err != nil {
+			t.Fatal(err)
+		}
+		contract, err := store.GetContract(claim.ContractUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contract.Void {
+			t.Error("contract is void after reimbursing damage")
+		}
+	})
+}
+
+func TestClaimTransitionsTable(t *testing.T) {
+	seen := map[ClaimAction]bool{}
+	for _, transition := range claimTransitions {
+		if seen[transition.Action] {
+			t.Errorf("action %s is listed twice", transition.Action)
+		}
+		seen[transition.Action] = true
+
+		if len(transition.From) == 0 || len(transition.Roles) == 0 {
+			t.Errorf("action %s has no source status or role", transition.Action)
+		}
+		if transition.To == ClaimStatusUnknown || slices.Contains(transition.From, ClaimStatusUnknown) {
+			t.Errorf("action %s involves the unknown status", transition.Action)
+		}
+	}
+}
+
+func TestNextClaimActions(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim Claim
+		role  Role
+		want  []ClaimAction
+	}{
+		{name: "insurer on new claim", claim: Claim{Status: ClaimStatusNew}, role: RoleInsurer, want: []ClaimAction{ClaimActionReview, ClaimActionApproveRepair, ClaimActionApproveReimbursement, ClaimActionReject}},
+		{name: "insurer on unconfirmed theft", claim: Claim{Status: ClaimStatusNew, IsTheft: true}, role: RoleInsurer, want: []ClaimAction{}},
+		{name: "police on unconfirmed theft", claim: Claim{Status: ClaimStatusNew, IsTheft: true}, role: RolePolice, want: []ClaimAction{ClaimActionConfirmTheft, ClaimActionDenyTheft}},
+		{name: "insurer on unrepaired claim", claim: Claim{Status: ClaimStatusRepair}, role: RoleInsurer, want: []ClaimAction{}},
+		{name: "insurer on closed claim", claim: Claim{Status: ClaimStatusClosed}, role: RoleInsurer, want: []ClaimAction{ClaimActionReopen}},
+		{name: "customer", claim: Claim{Status: ClaimStatusNew}, role: RoleCustomer, want: []ClaimAction{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []ClaimAction{}
+			for _, next := range nextClaimActions(&User{Role: tt.role}, &tt.claim) {
+				got = append(got, next.Action)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("next actions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListContractsClaimsAfterTransfer(t *testing.T) {
+	store := newMemoryStore()
+	newTestClaim(t, store, Claim{Status: ClaimStatusClosed})
+	if err := store.CreateUser(&User{Username: "bob", Password: "secret", Role: RoleCustomer}); err != nil {
+		t.Fatal(err)
+	}
+	contract, err := store.GetContract("contract-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract.Username = "bob"
+	if err := store.SaveContract(contract); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		caller     *User
+		wantClaims int
+	}{
+		{name: "new owner", caller: &User{Username: "bob", Role: RoleCustomer}, wantClaims: 0},
+		{name: "insurer", caller: &User{Username: "insurer", Role: RoleInsurer}, wantClaims: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := listContracts(store, tt.caller, &ListContractsRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) != 1 {
+				t.Fatalf("listed %d contracts, want 1", len(page.Items))
+			}
+			if claims := page.Items[0].Claims; len(claims) != tt.wantClaims {
+				t.Errorf("contract lists %d claims, want %d", len(claims), tt.wantClaims)
+			}
+		})
+	}
+}
+
+func TestFileClaimIntakeRules(t *testing.T) {
+	customer := &User{Username: "alice", Role: RoleCustomer}
+
+	tests := []struct {
+		name     string
+		modify   func(contract *Contract, contractType *ContractType)
+		date     func(contract *Contract) time.Time
+		isTheft  bool
+		wantCode string
+	}{
+		{name: "accepted", date: func(c *Contract) time.Time { return c.StartDate.AddDate(0, 0, 1) }},
+		{name: "void contract", modify: func(c *Contract, ct *ContractType) { c.Void = true },
+			date: func(c *Contract) time.Time { return c.StartDate.AddDate(0, 0, 1) }, wantCode: "contract_void"},
+		{name: "first day of the period", date: func(c *Contract) time.Time { return c.StartDate }},
+		{name: "before the period", date: func(c *Contract) time.Time { return c.StartDate.Add(-time.Second) }, wantCode: "claim_outside_period"},
+		{name: "last day of the period", date: func(c *Contract) time.Time { return c.EndDate }},
+		{name: "after the period", date: func(c *Contract) time.Time { return c.EndDate.Add(time.Second) }, wantCode: "claim_outside_period"},
+		{name: "last day of the waiting period", modify: func(c *Contract, ct *ContractType) { ct.WaitingPeriodDays = 14 },
+			date: func(c *Contract) time.Time { return c.StartDate.AddDate(0, 0, 13) }, wantCode: "waiting_period"},
+		{name: "day the waiting period ends", modify: func(c *Contract, ct *ContractType) { ct.WaitingPeriodDays = 14 },
+			date: func(c *Contract) time.Time { return c.StartDate.AddDate(0, 0, 14) }},
+		{name: "theft covered", isTheft: true, date: func(c *Contract) time.Time { return c.StartDate.AddDate(0, 0, 1) }},
+		{name: "theft not covered", modify: func(c *Contract, ct *ContractType) { ct.TheftInsured = false },
+			isTheft: true, date: func(c *Contract) time.Time { return c.StartDate.AddDate(0, 0, 1) }, wantCode: "theft_not_covered"},
+		{name: "damage without theft cover", modify: func(c *Contract, ct *ContractType) { ct.TheftInsured = false },
+			date: func(c *Contract) time.Time { return c.StartDate.AddDate(0, 0, 1) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			newTestClaim(t, store, Claim{Status: ClaimStatusClosed})
+			contract, err := store.GetContract("contract-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			contractType, err := store.GetContractType(contract.ContractTypeUUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify != nil {
+				tt.modify(contract, contractType)
+				if err := store.SaveContract(contract); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.SaveContractType(contractType); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			claim, err := fileClaim(store, customer, &FileClaimRequest{
+				ContractUUID: contract.UUID,
+				Date:         tt.date(contract),
+				Description:  "broken screen",
+				IsTheft:      tt.isTheft,
+			})
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("fileClaim: %v", err)
+				}
+				if claim.Status != ClaimStatusNew || claim.FiledBy != customer.Username {
+					t.Errorf("claim status %s filed by %q, want %s filed by %s", claim.Status, claim.FiledBy, ClaimStatusNew, customer.Username)
+				}
+				return
+			}
+			if code := errorCode(err); code != tt.wantCode {
+				t.Errorf("fileClaim error = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestReimbursableBounds(t *testing.T) {
+	insurer := &User{Username: "insurer", Role: RoleInsurer}
+
+	tests := []struct {
+		name          string
+		maxSumInsured float32
+		amount        float32
+		wantCode      string
+	}{
+		{name: "nothing", maxSumInsured: 1000, amount: 0},
+		{name: "item price", maxSumInsured: 1000, amount: 500},
+		{name: "negative", maxSumInsured: 1000, amount: -1, wantCode: "reimbursable_out_of_range"},
+		{name: "above item price", maxSumInsured: 1000, amount: 500.01, wantCode: "reimbursable_out_of_range"},
+		{name: "maximum sum insured", maxSumInsured: 300, amount: 300},
+		{name: "above maximum sum insured", maxSumInsured: 300, amount: 300.01, wantCode: "reimbursable_out_of_range"},
+		{name: "no maximum sum insured", maxSumInsured: 0, amount: 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			claim := newTestClaim(t, store, Claim{Status: ClaimStatusUnderReview})
+			contractType, err := store.GetContractType("type-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			contractType.MaxSumInsured = tt.maxSumInsured
+			if err := store.SaveContractType(contractType); err != nil {
+				t.Fatal(err)
+			}
+
+			err = applyClaimTransition(store, insurer, claim, ClaimActionApproveReimbursement, claimTransitionParams{Reimbursable: tt.amount})
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("applyClaimTransition: %v", err)
+				}
+				if claim.Reimbursable != tt.amount {
+					t.Errorf("reimbursable = %v, want %v", claim.Reimbursable, tt.amount)
+				}
+				return
+			}
+			if code := errorCode(err); code != tt.wantCode {
+				t.Errorf("applyClaimTransition error = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
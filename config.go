@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the effective server configuration. Each field is resolved in
+// layers, later layers overriding earlier ones:
+//
+//  1. the defaults from defaultConfig
+//  2. the JSON config file given by --config or NFT_CONFIG
+//  3. NFT_<NAME> environment variables, e.g. NFT_DATABASE_DSN
+//  4. command-line flags, e.g. --database-dsn
+//
+// Secret fields can also be read from a file through <name>_file in the
+// config file, NFT_<NAME>_FILE or --<name>-file, so that they need not appear
+// in the environment. Secrets are not accepted as plain flags because
+// command lines are visible to other users of the host.
+type Config struct {
+	Store             string   `config:"store" json:"store"`
+	AutoMigrate       bool     `config:"auto_migrate" json:"auto_migrate"`
+	Port              int      `config:"port" json:"port"`
+	DatabaseDSN       string   `config:"database_dsn" secret:"true" json:"database_dsn"`
+	TimeZone          string   `config:"timezone" json:"timezone"`
+	CORSOrigins       []string `config:"cors_origins" json:"cors_origins"`
+	SigningKey        string   `config:"signing_key" secret:"true" json:"signing_key"`
+	InsurerUsername   string   `config:"insurer_username" json:"insurer_username"`
+	InsurerPassword   string   `config:"insurer_password" secret:"true" json:"insurer_password"`
+	IdempotencyWindow string   `config:"idempotency_window" json:"idempotency_window"`
+}
+
+// minSigningKeyLength is the shortest signing key accepted, in bytes.
+const minSigningKeyLength = 32
+
+// redacted replaces secret values in --print-config output.
+const redacted = "[redacted]"
+
+// placeholderPassword is the database password of the example DSN the
+// server used to connect with; a DSN still using it is rejected.
+const placeholderPassword = "yourpassword"
+
+// defaultConfig has no database DSN: the postgres store needs one to be
+// configured.
+func defaultConfig() *Config {
+	return &Config{
+		Store:             "postgres",
+		Port:              8080,
+		TimeZone:          "Asia/Shanghai",
+		CORSOrigins:       []string{"*"},
+		IdempotencyWindow: "24h",
+	}
+}
+
+// configField describes one settable field of Config.
+type configField struct {
+	name   string
+	secret bool
+	index  int
+}
+
+func (f configField) envName() string {
+	return "NFT_" + strings.ToUpper(f.name)
+}
+
+func (f configField) flagName() string {
+	return strings.ReplaceAll(f.name, "_", "-")
+}
+
+// configFields lists the fields of Config from their struct tags.
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+	fields := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fields = append(fields, configField{
+			name:   field.Tag.Get("config"),
+			secret: field.Tag.Get("secret") == "true",
+			index:  i,
+		})
+	}
+	return fields
+}
+
+// set parses value into the field according to its type.
+func (f configField) set(config *Config, value string) error {
+	target := reflect.ValueOf(config).Elem().Field(f.index)
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", f.name, value)
+		}
+		target.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", f.name, value)
+		}
+		target.SetInt(int64(n))
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		target.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported field type %s", f.name, target.Kind())
+	}
+	return nil
+}
+
+// readSecretFile reads a secret from a file, dropping the trailing newline
+// most editors and secret managers add.
+func readSecretFile(name, path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_file: %v", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// applyConfigFile overlays the settings of a JSON config file.
+func applyConfigFile(config *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	target := reflect.ValueOf(config).Elem()
+	for _, field := range configFields() {
+		if raw, ok := values[field.name]; ok {
+			delete(values, field.name)
+			if err := json.Unmarshal(raw, target.Field(field.index).Addr().Interface()); err != nil {
+				return fmt.Errorf("invalid config file %s: %s: %v", path, field.name, err)
+			}
+		}
+		if !field.secret {
+			continue
+		}
+		if raw, ok := values[field.name+"_file"]; ok {
+			delete(values, field.name+"_file")
+			var secretPath string
+			if err := json.Unmarshal(raw, &secretPath); err != nil {
+				return fmt.Errorf("invalid config file %s: %s_file: %v", path, field.name, err)
+			}
+			secret, err := readSecretFile(field.name, secretPath)
+			if err != nil {
+				return err
+			}
+			target.Field(field.index).SetString(secret)
+		}
+	}
+
+	// Reject typos rather than silently ignoring them
+	for key := range values {
+		return fmt.Errorf("invalid config file %s: unknown setting %q", path, key)
+	}
+	return nil
+}
+
+// applyConfigEnv overlays NFT_* environment variables.
+func applyConfigEnv(config *Config, getenv func(string) string) error {
+	for _, field := range configFields() {
+		if value := getenv(field.envName()); value != "" {
+			if err := field.set(config, value); err != nil {
+				return err
+			}
+		}
+		if !field.secret {
+			continue
+		}
+		if path := getenv(field.envName() + "_FILE"); path != "" {
+			secret, err := readSecretFile(field.name, path)
+			if err != nil {
+				return err
+			}
+			field.set(config, secret)
+		}
+	}
+	return nil
+}
+
+// configFlag collects a flag's raw value; it is parsed into Config by
+// configField.set once all layers are known.
+type configFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *configFlag) String() string { return f.value }
+
+func (f *configFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool { return f.isBool }
+
+// commandLine holds what the command line asks for besides configuration.
+type commandLine struct {
+	// PrintConfig is set by --print-config
+	PrintConfig bool
+	// Command is a subcommand such as ["migrate", "up"], empty to serve
+	Command []string
+}
+
+// loadConfig resolves the configuration from all layers and validates it.
+func loadConfig(args []string, getenv func(string) string) (*Config, *commandLine, error) {
+	var cmd commandLine
+	flags := flag.NewFlagSet("nft", flag.ContinueOnError)
+	configFile := flags.String("config", getenv("NFT_CONFIG"), "path to a JSON config file")
+	flags.BoolVar(&cmd.PrintConfig, "print-config", false, "print the effective configuration, with secrets redacted, and exit")
+
+	// Flags are only registered here; their values are applied after the
+	// config file and environment so that they take precedence
+	flagValues := map[string]*configFlag{}
+	for _, field := range configFields() {
+		value := &configFlag{}
+		if field.secret {
+			flagValues[field.flagName()+"-file"] = value
+			flags.Var(value, field.flagName()+"-file", "file containing "+field.name)
+		} else {
+			value.isBool = reflect.TypeOf(Config{}).Field(field.index).Type.Kind() == reflect.Bool
+			flagValues[field.flagName()] = value
+			flags.Var(value, field.flagName(), field.name+" (env "+field.envName()+")")
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	cmd.Command = flags.Args()
+
+	config := defaultConfig()
+	if *configFile != "" {
+		if err := applyConfigFile(config, *configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := applyConfigEnv(config, getenv); err != nil {
+		return nil, nil, err
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		if flagErr != nil {
+			return
+		}
+		for _, field := range configFields() {
+			switch {
+			case field.secret && f.Name == field.flagName()+"-file":
+				secret, err := readSecretFile(field.name, flagValues[f.Name].value)
+				if err != nil {
+					flagErr = err
+					return
+				}
+				field.set(config, secret)
+			case !field.secret && f.Name == field.flagName():
+				flagErr = field.set(config, flagValues[f.Name].value)
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, nil, err
+	}
+	return config, &cmd, nil
+}
+
+// validate reports every invalid setting at once.
+func (c *Config) validate() error {
+	var errs []error
+
+	switch c.Store {
+	case "postgres":
+		if err := checkPostgresDSN(c.DatabaseDSN); err != nil {
+			errs = append(errs, err)
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("store: unknown store %q, expected postgres or memory", c.Store))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port: %d is out of range", c.Port))
+	}
+
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		errs = append(errs, fmt.Errorf("timezone: %v", err))
+	}
+
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("cors_origins: %q is not an origin like https://example.com", origin))
+		}
+	}
+
+	if c.SigningKey != "" && len(c.SigningKey) < minSigningKeyLength {
+		errs = append(errs, fmt.Errorf("signing_key: must be at least %d bytes", minSigningKeyLength))
+	}
+
+	if (c.InsurerUsername == "") != (c.InsurerPassword == "") {
+		errs = append(errs, errors.New("insurer_username and insurer_password must be set together"))
+	}
+
+	if window, err := time.ParseDuration(c.IdempotencyWindow); err != nil {
+		errs = append(errs, fmt.Errorf("idempotency_window: %v", err))
+	} else if window <= 0 {
+		errs = append(errs, fmt.Errorf("idempotency_window: %s is not positive", c.IdempotencyWindow))
+	}
+
+	return errors.Join(errs...)
+}
+
+// idempotencyWindow returns how long responses to requests with an
+// Idempotency-Key are kept. validate has checked the setting.
+func (c *Config) idempotencyWindow() time.Duration {
+	window, _ := time.ParseDuration(c.IdempotencyWindow)
+	return window
+}
+
+// isPostgresURL reports whether a DSN is in the postgres:// URL form rather
+// than the key=value form.
+func isPostgresURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// checkPostgresDSN rejects a missing or malformed DSN, and one still using
+// the placeholder password.
+func checkPostgresDSN(dsn string) error {
+	if strings.TrimSpace(dsn) == "" {
+		return errors.New("database_dsn is required for the postgres store")
+	}
+
+	var password string
+	if isPostgresURL(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return errors.New("database_dsn: invalid postgres URL")
+		}
+		password, _ = u.User.Password()
+		if password == "" {
+			password = u.Query().Get("password")
+		}
+	} else {
+		for _, pair := range strings.Fields(dsn) {
+			if key, value, ok := strings.Cut(pair, "="); ok && key == "password" {
+				password = strings.Trim(value, "'")
+			}
+		}
+	}
+	if password == placeholderPassword {
+		return errors.New("database_dsn: uses the example password, set the real one")
+	}
+	return nil
+}
+
+// location returns the configured time zone. validate has checked the
+// setting.
+func (c *Config) location() *time.Location {
+	location, _ := time.LoadLocation(c.TimeZone)
+	return location
+}
+
+// postgresDSN returns the DSN with the configured time zone, unless the DSN
+// already sets one. URL DSNs take it as a query parameter, key=value DSNs
+// as another pair.
+func (c *Config) postgresDSN() string {
+	if isPostgresURL(c.DatabaseDSN) {
+		u, err := url.Parse(c.DatabaseDSN)
+		if err != nil {
+			return c.DatabaseDSN
+		}
+		query := u.Query()
+		for key := range query {
+			if strings.EqualFold(key, "timezone") {
+				return c.DatabaseDSN
+			}
+		}
+		query.Set("timezone", c.TimeZone)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	for _, pair := range strings.Fields(c.DatabaseDSN) {
+		if key, _, ok := strings.Cut(pair, "="); ok && strings.EqualFold(key, "timezone") {
+			return c.DatabaseDSN
+		}
+	}
+	return c.DatabaseDSN + " TimeZone=" + c.TimeZone
+}
+
+// Redacted returns a copy of the configuration with secrets masked.
+func (c *Config) Redacted() *Config {
+	redactedConfig := *c
+	target := reflect.ValueOf(&redactedConfig).Elem()
+	for _, field := range configFields() {
+		if field.secret && target.Field(field.index).String() != "" {
+			target.Field(field.index).SetString(redacted)
+		}
+	}
+	return &redactedConfig
+}
@@ -5,70 +5,88 @@ import (
 	"errors"
 	"strings"
 	"time"
-
-	"gorm.io/gorm"
 )
 
+// ContractType is a kind of insurance contract, with the coverage and
+// premium formula that contracts of the type are sold under.
 type ContractType struct {
-	UUID            string  `gorm:"primaryKey" json:"uuid"`
-	ShopType        string  `json:"shop_type"`
-	FormulaPerDay   string  `json:"formula_per_day"`
-	MaxSumInsured   float32 `json:"max_sum_insured"`
-	TheftInsured    bool    `json:"theft_insured"`
-	Description     string  `json:"description"`
-	Conditions      string  `json:"conditions"`
-	Active          bool    `json:"active"`
-	MinDurationDays int32   `json:"min_duration_days"`
-	MaxDurationDays int32   `json:"max_duration_days"`
+	UUID              string  `gorm:"primaryKey" json:"uuid"`
+	ShopType          string  `json:"shop_type"`
+	FormulaPerDay     string  `json:"formula_per_day"`
+	MaxSumInsured     float32 `json:"max_sum_insured"`
+	TheftInsured      bool    `json:"theft_insured"`
+	Description       string  `json:"description"`
+	Conditions        string  `json:"conditions"`
+	Active            bool    `json:"active"`
+	MinDurationDays   int32   `json:"min_duration_days"`
+	MaxDurationDays   int32   `json:"max_duration_days"`
+	WaitingPeriodDays int32   `json:"waiting_period_days"`
+	Transferable      *bool   `json:"transferable,omitempty"`
+	Version           int64   `gorm:"not null;default:1" json:"version"`
+}
+
+// IsTransferable reports whether contracts of this type may change owner.
+// Contract types that do not set the flag are transferable.
+func (ct ContractType) IsTransferable() bool {
+	return ct.Transferable == nil || *ct.Transferable
 }
 
 type User struct {
-	Username      string   `gorm:"primaryKey" json:"username"`
-	Password      string   `json:"password"`
-	FirstName     string   `json:"first_name"`
-	LastName      string   `json:"last_name"`
-	ContractIndex []string `gorm:"-" json:"contracts"` // Handled in application logic
+	Username  string     `gorm:"primaryKey" json:"username"`
+	Password  string     `json:"password"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	Role      Role       `gorm:"default:customer" json:"role"`
+	ShopType  string     `json:"shop_type,omitempty"` // Merchants only: the contract types they may sell
+	Contracts []Contract `gorm:"foreignKey:Username;references:Username;constraint:OnDelete:CASCADE" json:"contracts,omitempty"`
 }
 
 type Contract struct {
 	UUID             string    `gorm:"primaryKey" json:"uuid"`
-	Username         string    `json:"username"`
+	Number           string    `gorm:"uniqueIndex" json:"number"`
+	Username         string    `gorm:"not null" json:"username"`
 	Item             Item      `gorm:"embedded" json:"item"`
 	StartDate        time.Time `json:"start_date"`
 	EndDate          time.Time `json:"end_date"`
+	Premium          float32   `json:"premium"`
 	Void             bool      `json:"void"`
 	ContractTypeUUID string    `json:"contract_type_uuid"`
-	ClaimIndex       []string     `gorm:"foreignKey:ContractUUID" json:"claim_index,omitempty"`
-
-	//ClaimIndex       []string  `gorm:"-" json:"claim_index,omitempty"` // Handled in application logic
+	Version          int64     `gorm:"not null;default:1" json:"version"`
+	Claims           []Claim   `gorm:"foreignKey:ContractUUID;references:UUID;constraint:OnDelete:CASCADE" json:"claims,omitempty"`
 }
 
 type Item struct {
 	ID          int32   `json:"id"`
 	Brand       string  `json:"brand"`
 	Model       string  `json:"model"`
-	Price       float32 `json:"price"`
+	Price       float32 `json:"price" validate:"min=0"`
 	Description string  `json:"description"`
 	SerialNo    string  `json:"serial_no"`
 }
 
 type Claim struct {
-	UUID          string      `gorm:"primaryKey" json:"uuid"`
-	ContractUUID  string      `json:"contract_uuid"`
-	Date          time.Time   `json:"date"`
-	Description   string      `json:"description"`
-	IsTheft       bool        `json:"is_theft"`
-	Status        ClaimStatus `json:"status"`
-	Reimbursable  float32     `json:"reimbursable"`
-	Repaired      bool        `json:"repaired"`
-	FileReference string      `json:"file_reference"`
+	UUID          string       `gorm:"primaryKey" json:"uuid"`
+	Number        string       `gorm:"uniqueIndex" json:"number"`
+	ContractUUID  string       `gorm:"not null" json:"contract_uuid"`
+	FiledBy       string       `gorm:"not null" json:"filed_by"`
+	Date          time.Time    `json:"date"`
+	Description   string       `json:"description"`
+	IsTheft       bool         `json:"is_theft"`
+	Status        ClaimStatus  `json:"status"`
+	Reimbursable  float32      `json:"reimbursable"`
+	Repaired      bool         `json:"repaired"`
+	FileReference string       `json:"file_reference"`
+	Version       int64        `gorm:"not null;default:1" json:"version"`
+	RepairOrder   *RepairOrder `gorm:"foreignKey:ClaimUUID;references:UUID;constraint:OnDelete:CASCADE" json:"repair_order,omitempty"`
 }
 
 type RepairOrder struct {
-	ClaimUUID    string `json:"claim_uuid"`
+	UUID         string `gorm:"primaryKey" json:"uuid"`
+	ClaimUUID    string `gorm:"uniqueIndex;not null" json:"claim_uuid"`
 	ContractUUID string `json:"contract_uuid"`
-	Item         Item   `json:"item"`
+	Item         Item   `gorm:"embedded" json:"item"`
 	Ready        bool   `json:"ready"`
+	Version      int64  `gorm:"not null;default:1" json:"version"`
 }
 
 // ClaimStatus Enum
@@ -81,8 +99,34 @@ const (
 	ClaimStatusRepair
 	ClaimStatusReimbursement
 	ClaimStatusTheftConfirmed
+	ClaimStatusUnderReview
+	ClaimStatusClosed
+	ClaimStatusReopened
 )
 
+func (s ClaimStatus) String() string {
+	switch s {
+	case ClaimStatusNew:
+		return "new"
+	case ClaimStatusRejected:
+		return "rejected"
+	case ClaimStatusRepair:
+		return "repair"
+	case ClaimStatusReimbursement:
+		return "reimbursement"
+	case ClaimStatusTheftConfirmed:
+		return "theft confirmed"
+	case ClaimStatusUnderReview:
+		return "under review"
+	case ClaimStatusClosed:
+		return "closed"
+	case ClaimStatusReopened:
+		return "reopened"
+	default:
+		return "unknown"
+	}
+}
+
 func (s *ClaimStatus) UnmarshalJSON(b []byte) error {
 	var value string
 	if err := json.Unmarshal(b, &value); err != nil {
@@ -99,6 +143,12 @@ func (s *ClaimStatus) UnmarshalJSON(b []byte) error {
 		*s = ClaimStatusReimbursement
 	case "P":
 		*s = ClaimStatusTheftConfirmed
+	case "U":
+		*s = ClaimStatusUnderReview
+	case "C":
+		*s = ClaimStatusClosed
+	case "O":
+		*s = ClaimStatusReopened
 	default:
 		*s = ClaimStatusUnknown
 	}
@@ -118,6 +168,12 @@ func (s ClaimStatus) MarshalJSON() ([]byte, error) {
 		value = "F"
 	case ClaimStatusTheftConfirmed:
 		value = "P"
+	case ClaimStatusUnderReview:
+		value = "U"
+	case ClaimStatusClosed:
+		value = "C"
+	case ClaimStatusReopened:
+		value = "O"
 	default:
 		value = ""
 	}
@@ -150,47 +206,19 @@ func CreateUserAndAddress(db *gorm.DB, user User, address Address) error {
 }
 */
 
-func (u *User) Contracts(db *gorm.DB) ([]Contract, error) {
-	var contracts []Contract
-	err := db.Where("uuid IN ?", u.ContractIndex).Find(&contracts).Error
-	if err != nil {
-		return nil, err
-	}
-	return contracts, nil
-}
-
-func (c *Contract) Claims(db *gorm.DB) ([]Claim, error) {
-	var claims []Claim
-	err := db.Where("uuid IN ?", c.ClaimIndex).Find(&claims).Error
-	if err != nil {
-		return nil, err
-	}
-	return claims, nil
-}
-
-func (c *Contract) User(db *gorm.DB) (*User, error) {
+func (c *Contract) User(store Store) (*User, error) {
 	if c.Username == "" {
 		return nil, errors.New("invalid username in contract")
 	}
 
-	var user User
-	err := db.Where("username = ?", c.Username).First(&user).Error
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return store.GetUser(c.Username)
 }
 
-func (c *Claim) Contract(db *gorm.DB) (*Contract, error) {
+func (c *Claim) Contract(store Store) (*Contract, error) {
 	if c.ContractUUID == "" {
 		return nil, errors.New("contract UUID is missing in claim")
 	}
 
-	var contract Contract
-	err := db.Where("uuid = ?", c.ContractUUID).First(&contract).Error
-	if err != nil {
-		return nil, err
-	}
-	return &contract, nil
+	return store.GetContract(c.ContractUUID)
 }
 
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ErrorKind classifies domain errors and decides their HTTP status.
+type ErrorKind int
+
+const (
+	ErrorKindInternal ErrorKind = iota
+	ErrorKindValidation
+	ErrorKindUnauthorized
+	ErrorKindForbidden
+	ErrorKindNotFound
+	ErrorKindConflict
+	ErrorKindPreconditionFailed
+	ErrorKindPreconditionRequired
+	ErrorKindUnprocessable
+)
+
+// Status returns the HTTP status code for the kind.
+func (k ErrorKind) Status() int {
+	switch k {
+	case ErrorKindValidation:
+		return http.StatusBadRequest
+	case ErrorKindUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorKindForbidden:
+		return http.StatusForbidden
+	case ErrorKindNotFound:
+		return http.StatusNotFound
+	case ErrorKindConflict:
+		return http.StatusConflict
+	case ErrorKindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	case ErrorKindPreconditionRequired:
+		return http.StatusPreconditionRequired
+	case ErrorKindUnprocessable:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is a domain error returned by handlers. Code is a stable,
+// machine-readable identifier such as "contract_not_found"; Message is for
+// humans and may change. Details lists the invalid fields of a request that
+// failed validation.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	Details []FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(kind ErrorKind, code, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func validationError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindValidation, code, format, args...)
+}
+
+func unauthorizedError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindUnauthorized, code, format, args...)
+}
+
+func forbiddenError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindForbidden, code, format, args...)
+}
+
+func notFoundError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindNotFound, code, format, args...)
+}
+
+func conflictError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindConflict, code, format, args...)
+}
+
+func preconditionFailedError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindPreconditionFailed, code, format, args...)
+}
+
+func preconditionRequiredError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindPreconditionRequired, code, format, args...)
+}
+
+func unprocessableError(code, format string, args ...interface{}) error {
+	return newError(ErrorKindUnprocessable, code, format, args...)
+}
+
+// invalidInput reports a request body that could not be decoded.
+func invalidInput(err error) error {
+	return validationError("invalid_input", "invalid input: %v", err)
+}
+
+// errorResponse is the JSON body of every error response.
+type errorResponse struct {
+	Error   string       `json:"error"`
+	Code    string       `json:"code"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// writeError writes err as a JSON error response. Store errors a handler
+// did not translate are reported as conflicts. Other errors that are not
+// domain errors are logged and reported as a generic internal error so that
+// storage details do not leak to clients.
+func writeError(w http.ResponseWriter, err error) {
+	var domainErr *Error
+	switch {
+	case errors.As(err, &domainErr):
+	case errors.Is(err, ErrDuplicate):
+		domainErr = newError(ErrorKindConflict, "duplicate_record", "the record already exists")
+	case errors.Is(err, ErrMissingReference):
+		domainErr = newError(ErrorKindConflict, "missing_reference", "a record referenced by the request does not exist")
+	default:
+		log.Printf("Internal error: %v", err)
+		domainErr = &Error{Kind: ErrorKindInternal, Code: "internal_error", Message: "internal server error"}
+	}
+
+	if domainErr.Kind == ErrorKindUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="nft"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(domainErr.Kind.Status())
+	json.NewEncoder(w).Encode(errorResponse{Error: domainErr.Message, Code: domainErr.Code, Details: domainErr.Details})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "domain error", err: notFoundError("claim_not_found", "claim not found"), wantStatus: http.StatusNotFound, wantCode: "claim_not_found"},
+		{name: "wrapped domain error", err: fmt.Errorf("failed to update claim: %w", ErrVersionConflict), wantStatus: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "duplicate", err: fmt.Errorf("failed to create user: %w", duplicateKey("user", "alice")), wantStatus: http.StatusConflict, wantCode: "duplicate_record"},
+		{name: "missing reference", err: fmt.Errorf("failed to create contract: %w", ErrMissingReference), wantStatus: http.StatusConflict, wantCode: "missing_reference"},
+		{name: "unauthorized", err: unauthorizedError("invalid_token", "invalid token"), wantStatus: http.StatusUnauthorized, wantCode: "invalid_token"},
+		{name: "other error", err: errors.New("connection refused"), wantStatus: http.StatusInternalServerError, wantCode: "internal_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			var body errorResponse
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", body.Code, tt.wantCode)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
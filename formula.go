@@ -0,0 +1,909 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits that keep formula evaluation sandboxed. Formulas have no loops or
+// side effects, so bounding their size and nesting is enough to bound the
+// cost of evaluating them. Depth counts nested parentheses, function
+// arguments, conditional branches and unary operands; terms count every
+// literal, variable, operator and function call.
+const (
+	maxFormulaLength = 1024
+	maxFormulaDepth  = 32
+	maxFormulaNodes  = 256
+)
+
+// formulaType is the type of a formula value, known when it is compiled.
+type formulaType int
+
+const (
+	formulaNumberType formulaType = iota + 1
+	formulaStringType
+	formulaBoolType
+)
+
+func (t formulaType) String() string {
+	switch t {
+	case formulaNumberType:
+		return "number"
+	case formulaStringType:
+		return "string"
+	case formulaBoolType:
+		return "boolean"
+	default:
+		return "unknown"
+	}
+}
+
+// formulaVariables lists every variable a ContractType.FormulaPerDay may
+// reference, with its type. Formulas referring to anything else are
+// rejected when the contract type is created.
+var formulaVariables = map[string]formulaType{
+	"price":            formulaNumberType,
+	"days":             formulaNumberType,
+	"max_sum_insured":  formulaNumberType,
+	"theft_insured":    formulaBoolType,
+	"item.id":          formulaNumberType,
+	"item.brand":       formulaStringType,
+	"item.model":       formulaStringType,
+	"item.price":       formulaNumberType,
+	"item.description": formulaStringType,
+	"item.serial_no":   formulaStringType,
+}
+
+// formulaFunctions maps the callable functions to their accepted argument
+// counts (-1 for variadic with at least one argument).
+var formulaFunctions = map[string]int{
+	"min":      -1,
+	"max":      -1,
+	"abs":      1,
+	"round":    -1,
+	"floor":    1,
+	"ceil":     1,
+	"if":       3,
+	"lower":    1,
+	"upper":    1,
+	"contains": 2,
+}
+
+// Formula is a parsed, type-checked premium formula.
+type Formula struct {
+	source string
+	root   formulaNode
+	kind   formulaType
+}
+
+// formulaNode is a node of a parsed formula. check returns the type eval
+// yields, or an error if the operands have the wrong types for it.
+type formulaNode interface {
+	check() (formulaType, error)
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+// compileFormula parses a formula and checks that it only references known
+// variables and functions, with operands of the right types. Errors that
+// depend on the values, such as a division by zero, only surface in Eval.
+func compileFormula(source string) (*Formula, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, errors.New("formula is empty")
+	}
+	if len(source) > maxFormulaLength {
+		return nil, fmt.Errorf("formula exceeds %d characters", maxFormulaLength)
+	}
+
+	tokens, err := tokenizeFormula(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &formulaParser{tokens: tokens}
+	root, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+
+	kind, err := root.check()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Formula{source: source, root: root, kind: kind}, nil
+}
+
+// Eval evaluates the formula against the given variables.
+func (f *Formula) Eval(vars map[string]interface{}) (interface{}, error) {
+	return f.root.eval(vars)
+}
+
+// EvalNumber evaluates the formula and requires a finite numeric result.
+func (f *Formula) EvalNumber(vars map[string]interface{}) (float64, error) {
+	value, err := f.Eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("formula must evaluate to a number, got %s", formulaTypeName(value))
+	}
+	if math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, errors.New("formula result is not a finite number")
+	}
+	return number, nil
+}
+
+// validateFormula compiles a formula and checks that it yields a number, so
+// that type errors (e.g. a formula yielding a string) are caught when the
+// contract type is created rather than when the first contract is sold. The
+// formula is not evaluated: whether it succeeds depends on the item and
+// period, which are only known when a premium is quoted.
+func validateFormula(contractType ContractType) error {
+	formula, err := compileFormula(contractType.FormulaPerDay)
+	if err != nil {
+		return err
+	}
+	if formula.kind != formulaNumberType {
+		return fmt.Errorf("formula must evaluate to a number, got %s", formula.kind)
+	}
+	return nil
+}
+
+// premiumVariables builds the variable set a premium formula is evaluated
+// against.
+func premiumVariables(contractType ContractType, item Item, days int32) map[string]interface{} {
+	return map[string]interface{}{
+		"price":            float64(item.Price),
+		"days":             float64(days),
+		"max_sum_insured":  float64(contractType.MaxSumInsured),
+		"theft_insured":    contractType.TheftInsured,
+		"item.id":          float64(item.ID),
+		"item.brand":       item.Brand,
+		"item.model":       item.Model,
+		"item.price":       float64(item.Price),
+		"item.description": item.Description,
+		"item.serial_no":   item.SerialNo,
+	}
+}
+
+// contractDurationDays returns the number of started days between start and
+// end.
+func contractDurationDays(start, end time.Time) int32 {
+	hours := end.Sub(start).Hours()
+	if hours <= 0 {
+		return 0
+	}
+	return int32(math.Ceil(hours / 24))
+}
+
+// computePremium evaluates the contract type's per-day formula and returns
+// the premium for the whole contract period, rounded to cents.
+func computePremium(contractType ContractType, item Item, startDate, endDate time.Time) (float32, error) {
+	formula, err := compileFormula(contractType.FormulaPerDay)
+	if err != nil {
+		return 0, fmt.Errorf("invalid formula for contract type %s: %v", contractType.UUID, err)
+	}
+
+	days := contractDurationDays(startDate, endDate)
+	if days <= 0 {
+		return 0, errors.New("end date must be after start date")
+	}
+
+	perDay, err := formula.EvalNumber(premiumVariables(contractType, item, days))
+	if err != nil {
+		return 0, fmt.Errorf("failed to evaluate formula: %v", err)
+	}
+	if perDay < 0 {
+		return 0, errors.New("formula evaluated to a negative premium")
+	}
+
+	return float32(math.Round(perDay*float64(days)*100) / 100), nil
+}
+
+// Tokenizer
+
+type formulaTokenKind int
+
+const (
+	tokenEOF formulaTokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type formulaToken struct {
+	kind formulaTokenKind
+	text string
+	pos  int
+}
+
+var formulaOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")", ","}
+
+func tokenizeFormula(source string) ([]formulaToken, error) {
+	var tokens []formulaToken
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, formulaToken{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, formulaToken{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, formulaToken{kind: tokenString, text: sb.String(), pos: start})
+
+		default:
+			matched := false
+			for _, op := range formulaOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, formulaToken{kind: tokenOperator, text: op, pos: i})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+		}
+	}
+
+	return append(tokens, formulaToken{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// Parser
+
+type formulaParser struct {
+	tokens []formulaToken
+	pos    int
+	nodes  int
+}
+
+// Binary operator precedence; higher binds tighter.
+var formulaPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+func (p *formulaParser) peek() formulaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *formulaParser) next() formulaToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *formulaParser) expect(op string) error {
+	tok := p.next()
+	if tok.kind != tokenOperator || tok.text != op {
+		if tok.kind == tokenEOF {
+			return fmt.Errorf("expected %q at end of formula", op)
+		}
+		return fmt.Errorf("expected %q at position %d, got %q", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+// node counts one node of the syntax tree against maxFormulaNodes.
+func (p *formulaParser) node() error {
+	p.nodes++
+	if p.nodes > maxFormulaNodes {
+		return fmt.Errorf("formula exceeds %d terms", maxFormulaNodes)
+	}
+	return nil
+}
+
+// nest checks the depth of a nested expression against maxFormulaDepth.
+func nest(depth int) error {
+	if depth > maxFormulaDepth {
+		return fmt.Errorf("formula nesting exceeds %d levels", maxFormulaDepth)
+	}
+	return nil
+}
+
+// parseExpression parses a conditional expression: cond ? a : b.
+func (p *formulaParser) parseExpression(depth int) (formulaNode, error) {
+	if err := nest(depth); err != nil {
+		return nil, err
+	}
+
+	cond, err := p.parseBinary(depth, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == "?" {
+		p.next()
+		if err := p.node(); err != nil {
+			return nil, err
+		}
+		then, err := p.parseExpression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		otherwise, err := p.parseExpression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &conditionalNode{cond: cond, then: then, otherwise: otherwise}, nil
+	}
+
+	return cond, nil
+}
+
+func (p *formulaParser) parseBinary(depth, minPrecedence int) (formulaNode, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		precedence, ok := formulaPrecedence[tok.text]
+		if tok.kind != tokenOperator || !ok || precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+
+		if err := p.node(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseBinary(depth, precedence+1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *formulaParser) parseUnary(depth int) (formulaNode, error) {
+	tok := p.peek()
+	if tok.kind == tokenOperator && (tok.text == "-" || tok.text == "!") {
+		p.next()
+		if err := p.node(); err != nil {
+			return nil, err
+		}
+		if err := nest(depth + 1); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: tok.text, operand: operand}, nil
+	}
+
+	return p.parsePrimary(depth)
+}
+
+func (p *formulaParser) parsePrimary(depth int) (formulaNode, error) {
+	tok := p.next()
+	if tok.kind != tokenEOF && !(tok.kind == tokenOperator && tok.text == "(") {
+		if err := p.node(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch tok.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return &literalNode{value: value}, nil
+
+	case tokenString:
+		return &literalNode{value: tok.text}, nil
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		}
+
+		if next := p.peek(); next.kind == tokenOperator && next.text == "(" {
+			return p.parseCall(depth, tok)
+		}
+
+		name := strings.ToLower(tok.text)
+		if _, ok := formulaVariables[name]; !ok {
+			return nil, fmt.Errorf("unknown variable %q at position %d", tok.text, tok.pos)
+		}
+		return &variableNode{name: name}, nil
+
+	case tokenOperator:
+		if tok.text == "(" {
+			inner, err := p.parseExpression(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+
+	default:
+		return nil, errors.New("unexpected end of formula")
+	}
+}
+
+func (p *formulaParser) parseCall(depth int, name formulaToken) (formulaNode, error) {
+	function := strings.ToLower(name.text)
+	arity, ok := formulaFunctions[function]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+
+	p.next() // consume "("
+	var args []formulaNode
+	if tok := p.peek(); !(tok.kind == tokenOperator && tok.text == ")") {
+		for {
+			arg, err := p.parseExpression(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if tok := p.peek(); tok.kind == tokenOperator && tok.text == "," {
+				p.next()
+				continue
+			}
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case arity >= 0 && len(args) != arity:
+		return nil, fmt.Errorf("function %s expects %d arguments, got %d", function, arity, len(args))
+	case arity < 0 && len(args) == 0:
+		return nil, fmt.Errorf("function %s expects at least one argument", function)
+	case function == "round" && len(args) > 2:
+		return nil, fmt.Errorf("function round expects 1 or 2 arguments, got %d", len(args))
+	}
+
+	return &callNode{function: function, args: args}, nil
+}
+
+// Type checking
+
+func (n *literalNode) check() (formulaType, error) {
+	switch n.value.(type) {
+	case float64:
+		return formulaNumberType, nil
+	case string:
+		return formulaStringType, nil
+	case bool:
+		return formulaBoolType, nil
+	default:
+		return 0, fmt.Errorf("unsupported literal %v", n.value)
+	}
+}
+
+func (n *variableNode) check() (formulaType, error) {
+	return formulaVariables[n.name], nil
+}
+
+func (n *unaryNode) check() (formulaType, error) {
+	if n.op == "!" {
+		return formulaBoolType, expectFormulaType(n.operand, formulaBoolType)
+	}
+	return formulaNumberType, expectFormulaType(n.operand, formulaNumberType)
+}
+
+func (n *binaryNode) check() (formulaType, error) {
+	left, err := n.left.check()
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.check()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		if left != formulaBoolType || right != formulaBoolType {
+			return 0, fmt.Errorf("%s expects booleans, got %s and %s", n.op, left, right)
+		}
+		return formulaBoolType, nil
+	case "==", "!=":
+		if left != right {
+			return 0, fmt.Errorf("cannot compare %s with %s", left, right)
+		}
+		return formulaBoolType, nil
+	case "+":
+		// String concatenation
+		if left == formulaStringType && right == formulaStringType {
+			return formulaStringType, nil
+		}
+	}
+
+	if left != formulaNumberType || right != formulaNumberType {
+		return 0, fmt.Errorf("%s expects numbers, got %s and %s", n.op, left, right)
+	}
+	switch n.op {
+	case "<", "<=", ">", ">=":
+		return formulaBoolType, nil
+	default:
+		return formulaNumberType, nil
+	}
+}
+
+func (n *conditionalNode) check() (formulaType, error) {
+	if err := expectFormulaType(n.cond, formulaBoolType); err != nil {
+		return 0, err
+	}
+	then, err := n.then.check()
+	if err != nil {
+		return 0, err
+	}
+	otherwise, err := n.otherwise.check()
+	if err != nil {
+		return 0, err
+	}
+	if then != otherwise {
+		return 0, fmt.Errorf("conditional branches have different types: %s and %s", then, otherwise)
+	}
+	return then, nil
+}
+
+func (n *callNode) check() (formulaType, error) {
+	switch n.function {
+	case "if":
+		return (&conditionalNode{cond: n.args[0], then: n.args[1], otherwise: n.args[2]}).check()
+	case "lower", "upper":
+		if err := expectFormulaType(n.args[0], formulaStringType); err != nil {
+			return 0, fmt.Errorf("%s: %v", n.function, err)
+		}
+		return formulaStringType, nil
+	case "contains":
+		for _, arg := range n.args {
+			if err := expectFormulaType(arg, formulaStringType); err != nil {
+				return 0, fmt.Errorf("%s: %v", n.function, err)
+			}
+		}
+		return formulaBoolType, nil
+	}
+
+	for _, arg := range n.args {
+		if err := expectFormulaType(arg, formulaNumberType); err != nil {
+			return 0, fmt.Errorf("%s: %v", n.function, err)
+		}
+	}
+	return formulaNumberType, nil
+}
+
+// expectFormulaType checks node and requires it to yield want.
+func expectFormulaType(node formulaNode, want formulaType) error {
+	got, err := node.check()
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("expected a %s, got %s", want, got)
+	}
+	return nil
+}
+
+// Evaluation
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("variable %q is not set", n.name)
+	}
+	return value, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand formulaNode
+}
+
+func (n *unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "!" {
+		b, err := formulaBool(value)
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+	}
+
+	number, err := formulaNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	return -number, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right formulaNode
+}
+
+func (n *binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit logical operators
+	if n.op == "&&" || n.op == "||" {
+		lb, err := formulaBool(left)
+		if err != nil {
+			return nil, err
+		}
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		return formulaBool(right)
+	}
+
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return formulaEqual(left, right)
+	case "!=":
+		equal, err := formulaEqual(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return !equal, nil
+	case "+":
+		// String concatenation
+		if ls, ok := left.(string); ok {
+			if rs, ok := right.(string); ok {
+				return ls + rs, nil
+			}
+		}
+	}
+
+	ln, err := formulaNumber(left)
+	if err != nil {
+		return nil, err
+	}
+	rn, err := formulaNumber(right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/":
+		if rn == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return ln / rn, nil
+	case "%":
+		if rn == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(ln, rn), nil
+	case "<":
+		return ln < rn, nil
+	case "<=":
+		return ln <= rn, nil
+	case ">":
+		return ln > rn, nil
+	case ">=":
+		return ln >= rn, nil
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", n.op)
+	}
+}
+
+type conditionalNode struct {
+	cond, then, otherwise formulaNode
+}
+
+func (n *conditionalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := n.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := formulaBool(value)
+	if err != nil {
+		return nil, err
+	}
+	if cond {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+type callNode struct {
+	function string
+	args     []formulaNode
+}
+
+func (n *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	// if() only evaluates the selected branch
+	if n.function == "if" {
+		return (&conditionalNode{cond: n.args[0], then: n.args[1], otherwise: n.args[2]}).eval(vars)
+	}
+
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	switch n.function {
+	case "lower", "upper":
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s expects a string, got %s", n.function, formulaTypeName(args[0]))
+		}
+		if n.function == "lower" {
+			return strings.ToLower(s), nil
+		}
+		return strings.ToUpper(s), nil
+
+	case "contains":
+		s, ok1 := args[0].(string)
+		sub, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("contains expects two strings")
+		}
+		return strings.Contains(s, sub), nil
+	}
+
+	numbers := make([]float64, len(args))
+	for i, arg := range args {
+		number, err := formulaNumber(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", n.function, err)
+		}
+		numbers[i] = number
+	}
+
+	switch n.function {
+	case "min", "max":
+		result := numbers[0]
+		for _, number := range numbers[1:] {
+			if (n.function == "min" && number < result) || (n.function == "max" && number > result) {
+				result = number
+			}
+		}
+		return result, nil
+	case "abs":
+		return math.Abs(numbers[0]), nil
+	case "floor":
+		return math.Floor(numbers[0]), nil
+	case "ceil":
+		return math.Ceil(numbers[0]), nil
+	case "round":
+		scale := 1.0
+		if len(numbers) == 2 {
+			scale = math.Pow(10, math.Round(numbers[1]))
+		}
+		return math.Round(numbers[0]*scale) / scale, nil
+	default:
+		return nil, fmt.Errorf("unknown function %q", n.function)
+	}
+}
+
+func formulaNumber(value interface{}) (float64, error) {
+	number, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %s", formulaTypeName(value))
+	}
+	return number, nil
+}
+
+func formulaBool(value interface{}) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean, got %s", formulaTypeName(value))
+	}
+	return b, nil
+}
+
+func formulaEqual(left, right interface{}) (bool, error) {
+	if formulaTypeName(left) != formulaTypeName(right) {
+		return false, fmt.Errorf("cannot compare %s with %s", formulaTypeName(left), formulaTypeName(right))
+	}
+	return left == right, nil
+}
+
+func formulaTypeName(value interface{}) string {
+	switch value.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testFormulaVariables(days int32) map[string]interface{} {
+	contractType := ContractType{MaxSumInsured: 1000, TheftInsured: true}
+	item := Item{ID: 7, Brand: "Acme", Model: "X1", Price: 200, SerialNo: "SN-1"}
+	return premiumVariables(contractType, item, days)
+}
+
+func TestFormulaEval(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula string
+		days    int32
+		want    interface{}
+		wantErr string
+	}{
+		// Operator precedence
+		{name: "multiplication before addition", formula: "1 + 2 * 3", want: 7.0},
+		{name: "parentheses", formula: "(1 + 2) * 3", want: 9.0},
+		{name: "subtraction is left associative", formula: "10 - 4 - 3", want: 3.0},
+		{name: "division is left associative", formula: "64 / 4 / 2", want: 8.0},
+		{name: "modulo binds like multiplication", formula: "1 + 7 % 4 * 2", want: 7.0},
+		{name: "unary minus", formula: "-2 * -3", want: 6.0},
+		{name: "comparison before equality", formula: "1 < 2 == 3 < 4", want: true},
+		{name: "and before or", formula: "true || false && false", want: true},
+		{name: "not binds tighter than and", formula: "!false && false", want: false},
+		{name: "conditional binds loosest", formula: "days > 10 ? 1 + 1 : 2 * 3", days: 30, want: 2.0},
+		{name: "nested conditional", formula: "days < 10 ? 1 : days < 60 ? 2 : 3", days: 30, want: 2.0},
+		{name: "variables", formula: "price * 0.01 + max_sum_insured / 1000", want: 3.0},
+
+		// Short-circuit evaluation
+		{name: "and skips right operand", formula: "days > 30 && price / (days - 30) > 1", days: 30, want: false},
+		{name: "or skips right operand", formula: "days == 30 || price / (days - 30) > 1", days: 30, want: true},
+		{name: "if only evaluates selected branch", formula: "if(days == 30, price, price / (days - 30))", days: 30, want: 200.0},
+		{name: "if evaluates the other branch", formula: "if(days == 30, price, price / (days - 30))", days: 40, want: 20.0},
+		{name: "conditional only evaluates selected branch", formula: "days == 30 ? 0 : price / (days - 30)", days: 30, want: 0.0},
+
+		// Division by zero
+		{name: "division by zero", formula: "price / (days - 30)", days: 30, wantErr: "division by zero"},
+		{name: "modulo by zero", formula: "price % (days - 30)", days: 30, wantErr: "division by zero"},
+		{name: "division by zero after and", formula: "days == 30 && price / (days - 30) > 1", days: 30, wantErr: "division by zero"},
+
+		// Strings and numbers
+		{name: "string concatenation", formula: `item.brand + "-" + item.model`, want: "Acme-X1"},
+		{name: "string equality", formula: `lower(item.brand) == "acme"`, want: true},
+		{name: "contains", formula: `contains(upper(item.serial_no), "SN")`, want: true},
+		{name: "boolean variable", formula: "theft_insured ? 2 : 1", want: 2.0},
+		{name: "functions", formula: "round(max(abs(-1.234), min(1, 2)), 2) + floor(1.5) + ceil(1.5)", want: 4.23},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formula, err := compileFormula(tt.formula)
+			if err != nil {
+				t.Fatalf("compileFormula(%q): %v", tt.formula, err)
+			}
+			got, err := formula.Eval(testFormulaVariables(tt.days))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Eval(%q) error = %v, want %q", tt.formula, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval(%q): %v", tt.formula, err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval(%q) = %#v, want %#v", tt.formula, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula string
+		wantErr string
+	}{
+		{name: "empty", formula: "", wantErr: "formula is empty"},
+		{name: "too long", formula: strings.Repeat("1+", maxFormulaLength/2) + "1", wantErr: "exceeds 1024 characters"},
+		{name: "too deep", formula: strings.Repeat("(", maxFormulaDepth+1) + "1" + strings.Repeat(")", maxFormulaDepth+1), wantErr: "nesting exceeds 32 levels"},
+		{name: "too deep unary", formula: strings.Repeat("-", maxFormulaDepth+1) + "1", wantErr: "nesting exceeds 32 levels"},
+		{name: "too many terms", formula: strings.Repeat("1+", maxFormulaNodes) + "1", wantErr: "exceeds 256 terms"},
+		{name: "unknown variable", formula: "price * rate", wantErr: `unknown variable "rate"`},
+		{name: "unknown item field", formula: "item.weight", wantErr: `unknown variable "item.weight"`},
+		{name: "unknown function", formula: "sqrt(price)", wantErr: `unknown function "sqrt"`},
+		{name: "wrong arity", formula: "abs(1, 2)", wantErr: "abs expects 1 arguments, got 2"},
+		{name: "unterminated string", formula: `item.brand == "acme`, wantErr: "unterminated string"},
+		{name: "trailing input", formula: "1 2", wantErr: `unexpected "2"`},
+		{name: "missing parenthesis", formula: "(1 + 2", wantErr: `expected ")"`},
+		{name: "number plus string", formula: "price + item.brand", wantErr: "+ expects numbers, got number and string"},
+		{name: "string arithmetic", formula: `item.brand * 2`, wantErr: "* expects numbers"},
+		{name: "comparing string with number", formula: "item.brand == 1", wantErr: "cannot compare string with number"},
+		{name: "ordering strings", formula: `item.brand < "b"`, wantErr: "< expects numbers"},
+		{name: "number as condition", formula: "price ? 1 : 2", wantErr: "expected a boolean, got number"},
+		{name: "mismatched branches", formula: `theft_insured ? 1 : "none"`, wantErr: "different types: number and string"},
+		{name: "lower of number", formula: "lower(price)", wantErr: "lower: expected a string, got number"},
+		{name: "max of string", formula: "max(price, item.model)", wantErr: "max: expected a number, got string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileFormula(tt.formula)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("compileFormula(%q) error = %v, want %q", tt.formula, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestFormulaLimits checks that formulas at the documented limits compile
+// and that one more level or term is rejected.
+func TestFormulaLimits(t *testing.T) {
+	parens := func(n int) string { return strings.Repeat("(", n) + "1" + strings.Repeat(")", n) }
+	calls := func(n int) string { return strings.Repeat("min(", n) + "1" + strings.Repeat(")", n) }
+	unary := func(n int) string { return strings.Repeat("-", n) + "1" }
+	conditionals := func(n int) string { return strings.Repeat("theft_insured ? 1 : ", n) + "1" }
+	// terms builds a formula of n terms: 1+1+...+1, negating the first
+	// operand when n is even
+	terms := func(n int) string {
+		return strings.Repeat("-", 1-n%2) + "1" + strings.Repeat("+1", (n-1)/2)
+	}
+
+	tests := []struct {
+		name    string
+		formula string
+		wantErr string
+	}{
+		{name: "parentheses at limit", formula: parens(maxFormulaDepth)},
+		{name: "parentheses over limit", formula: parens(maxFormulaDepth + 1), wantErr: "nesting exceeds 32 levels"},
+		{name: "calls at limit", formula: calls(maxFormulaDepth)},
+		{name: "calls over limit", formula: calls(maxFormulaDepth + 1), wantErr: "nesting exceeds 32 levels"},
+		{name: "unary at limit", formula: unary(maxFormulaDepth)},
+		{name: "unary over limit", formula: unary(maxFormulaDepth + 1), wantErr: "nesting exceeds 32 levels"},
+		{name: "conditionals at limit", formula: conditionals(maxFormulaDepth)},
+		{name: "conditionals over limit", formula: conditionals(maxFormulaDepth + 1), wantErr: "nesting exceeds 32 levels"},
+		{name: "binary operators do not nest", formula: "(" + terms(maxFormulaNodes-1) + ")"},
+		{name: "even terms at limit", formula: terms(maxFormulaNodes)},
+		{name: "even terms over limit", formula: terms(maxFormulaNodes) + "+1", wantErr: "exceeds 256 terms"},
+		{name: "odd terms at limit", formula: terms(maxFormulaNodes - 1)},
+		{name: "odd terms over limit", formula: terms(maxFormulaNodes + 1), wantErr: "exceeds 256 terms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileFormula(tt.formula)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("compileFormula(%q): %v", tt.formula, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("compileFormula(%q) error = %v, want %q", tt.formula, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateFormula(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula string
+		wantErr string
+	}{
+		{name: "number", formula: "price * 0.01"},
+		{name: "fails only for some periods", formula: "price / (days - 30)"},
+		{name: "fails only for some items", formula: "max_sum_insured / (price - 100)"},
+		{name: "string result", formula: "item.brand", wantErr: "must evaluate to a number, got string"},
+		{name: "boolean result", formula: "price > 100", wantErr: "must evaluate to a number, got boolean"},
+		{name: "invalid", formula: "price *", wantErr: "end of formula"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFormula(ContractType{FormulaPerDay: tt.formula})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateFormula(%q): %v", tt.formula, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateFormula(%q) error = %v, want %q", tt.formula, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormulaEvalNumber(t *testing.T) {
+	formula, err := compileFormula("item.brand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := formula.EvalNumber(testFormulaVariables(30)); err == nil || !strings.Contains(err.Error(), "got string") {
+		t.Fatalf("EvalNumber error = %v, want a type error", err)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormStore implements Store on top of GORM.
+type gormStore struct {
+	db *gorm.DB
+}
+
+func newGormStore(db *gorm.DB) *gormStore {
+	return &gormStore{db: db}
+}
+
+// forUpdate locks the rows a query selects until the transaction ends.
+func forUpdate(query *gorm.DB) *gorm.DB {
+	return query.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// first loads the first record matching the query into dest, translating
+// gorm.ErrRecordNotFound into ErrNotFound.
+func first(query *gorm.DB, dest interface{}) error {
+	err := query.First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// create inserts value without its associations, translating
+// gorm.ErrDuplicatedKey into ErrDuplicate and gorm.ErrForeignKeyViolated
+// into ErrMissingReference.
+func create(db *gorm.DB, value interface{}) error {
+	err := db.Omit(clause.Associations).Create(value).Error
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return ErrMissingReference
+	}
+	return err
+}
+
+// update saves a versioned record, found by query, if it still has the
+// version it was loaded with, and increments the version.
+func update(db *gorm.DB, record interface{}, version *int64, query string, args ...interface{}) error {
+	loaded := *version
+	*version = loaded + 1
+	result := db.Model(record).Where(query, args...).Where("version = ?", loaded).Select("*").Omit(clause.Associations).Updates(record)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrVersionConflict
+	}
+	if result.Error != nil {
+		*version = loaded
+	}
+	return result.Error
+}
+
+// find loads the page of query that opts asks for into dest, counting the
+// matching records first if opts.Total is set. Column names come from spec,
+// never from the client.
+func find[T any](query *gorm.DB, dest *[]T, spec listSpec[T], opts ListOptions) error {
+	if opts.Total != nil {
+		if err := query.Session(&gorm.Session{}).Count(opts.Total).Error; err != nil {
+			return err
+		}
+	}
+
+	column := opts.Sort
+	if column == "" {
+		column = spec.DefaultSort
+	}
+	op := ">"
+	if opts.Descending {
+		op = "<"
+	}
+
+	if opts.After != nil {
+		if column == spec.Key {
+			query = query.Where(spec.Key+" "+op+" ?", opts.After.Key)
+		} else {
+			query = query.Where("("+column+" "+op+" ? OR ("+column+" = ? AND "+spec.Key+" "+op+" ?))",
+				opts.After.Value, opts.After.Value, opts.After.Key)
+		}
+	}
+	query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: opts.Descending})
+	if column != spec.Key {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: spec.Key}, Desc: opts.Descending})
+	}
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+
+	return query.Find(dest).Error
+}
+
+// whereRange restricts column to [from, to), zero bounds being open.
+func whereRange(query *gorm.DB, column string, from, to time.Time) *gorm.DB {
+	if !from.IsZero() {
+		query = query.Where(column+" >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where(column+" < ?", to)
+	}
+	return query
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, with \ as the escape
+// character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// whereContains restricts column to values containing s, ignoring case.
+// Wildcards in s match only themselves.
+func whereContains(query *gorm.DB, column, s string) *gorm.DB {
+	return query.Where(column+` ILIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(s)+"%")
+}
+
+// Transaction runs fn in a database transaction, nested ones becoming
+// savepoints.
+func (s *gormStore) Transaction(fn func(tx Store) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(newGormStore(tx))
+	})
+}
+
+// Users
+
+func (s *gormStore) GetUser(username string) (*User, error) {
+	var user User
+	if err := first(s.db.Where("username = ?", username), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *gormStore) CreateUser(user *User) error {
+	return create(s.db, user)
+}
+
+func (s *gormStore) SaveUser(user *User) error {
+	return s.db.Omit(clause.Associations).Save(user).Error
+}
+
+// Contract types
+
+func (s *gormStore) GetContractType(uuid string) (*ContractType, error) {
+	var contractType ContractType
+	if err := first(s.db.Where("uuid = ?", uuid), &contractType); err != nil {
+		return nil, err
+	}
+	return &contractType, nil
+}
+
+func (s *gormStore) ListContractTypes(filter ContractTypeFilter) ([]ContractType, error) {
+	query := s.db.Model(&ContractType{})
+	if filter.Active != nil {
+		query = query.Where("active = ?", *filter.Active)
+	}
+	if filter.ShopType != "" {
+		query = whereContains(query, "shop_type", filter.ShopType)
+	}
+
+	var contractTypes []ContractType
+	err := find(query, &contractTypes, contractTypeList, filter.ListOptions)
+	return contractTypes, err
+}
+
+func (s *gormStore) CreateContractType(contractType *ContractType) error {
+	contractType.Version = 1
+	return create(s.db, contractType)
+}
+
+func (s *gormStore) SaveContractType(contractType *ContractType) error {
+	return update(s.db, contractType, &contractType.Version, "uuid = ?", contractType.UUID)
+}
+
+// Contracts
+
+func (s *gormStore) GetContract(uuid string) (*Contract, error) {
+	var contract Contract
+	if err := first(s.db.Where("uuid = ?", uuid), &contract); err != nil {
+		return nil, err
+	}
+	return &contract, nil
+}
+
+func (s *gormStore) GetContractForUpdate(uuid string) (*Contract, error) {
+	var contract Contract
+	if err := first(forUpdate(s.db).Where("uuid = ?", uuid), &contract); err != nil {
+		return nil, err
+	}
+	return &contract, nil
+}
+
+func (s *gormStore) ListContracts(filter ContractFilter) ([]Contract, error) {
+	query := s.db.Model(&Contract{}).Preload("Claims", func(db *gorm.DB) *gorm.DB {
+		return db.Order(claimList.DefaultSort)
+	})
+	if filter.Number != "" {
+		query = query.Where("number = ?", filter.Number)
+	}
+	if filter.Username != "" {
+		query = query.Where("username = ?", filter.Username)
+	}
+	if filter.ContractTypeUUID != "" {
+		query = query.Where("contract_type_uuid = ?", filter.ContractTypeUUID)
+	}
+	if filter.Void != nil {
+		query = query.Where("void = ?", *filter.Void)
+	}
+	query = whereRange(query, "start_date", filter.StartFrom, filter.StartTo)
+
+	var contracts []Contract
+	err := find(query, &contracts, contractList, filter.ListOptions)
+	return contracts, err
+}
+
+func (s *gormStore) CreateContract(contract *Contract) error {
+	contract.Version = 1
+	return create(s.db, contract)
+}
+
+func (s *gormStore) SaveContract(contract *Contract) error {
+	return update(s.db, contract, &contract.Version, "uuid = ?", contract.UUID)
+}
+
+// Claims
+
+func (s *gormStore) GetClaim(uuid string) (*Claim, error) {
+	var claim Claim
+	if err := first(s.db.Where("uuid = ?", uuid), &claim); err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+func (s *gormStore) GetClaimForUpdate(uuid string) (*Claim, error) {
+	var claim Claim
+	if err := first(forUpdate(s.db).Where("uuid = ?", uuid), &claim); err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+func (s *gormStore) ListClaims(filter ClaimFilter) ([]Claim, error) {
+	query := s.db.Model(&Claim{})
+	if filter.Number != "" {
+		query = query.Where("number = ?", filter.Number)
+	}
+	if filter.ContractUUID != "" {
+		query = query.Where("contract_uuid = ?", filter.ContractUUID)
+	}
+	if filter.Status != ClaimStatusUnknown {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.IsTheft != nil {
+		query = query.Where("is_theft = ?", *filter.IsTheft)
+	}
+	query = whereRange(query, "date", filter.DateFrom, filter.DateTo)
+
+	var claims []Claim
+	err := find(query, &claims, claimList, filter.ListOptions)
+	return claims, err
+}
+
+func (s *gormStore) CreateClaim(claim *Claim) error {
+	claim.Version = 1
+	return create(s.db, claim)
+}
+
+func (s *gormStore) SaveClaim(claim *Claim) error {
+	return update(s.db, claim, &claim.Version, "uuid = ?", claim.UUID)
+}
+
+// Repair orders
+
+func (s *gormStore) GetRepairOrder(uuid string) (*RepairOrder, error) {
+	var repairOrder RepairOrder
+	if err := first(s.db.Where("uuid = ?", uuid), &repairOrder); err != nil {
+		return nil, err
+	}
+	return &repairOrder, nil
+}
+
+func (s *gormStore) GetRepairOrderByClaim(claimUUID string) (*RepairOrder, error) {
+	var repairOrder RepairOrder
+	if err := first(s.db.Where("claim_uuid = ?", claimUUID), &repairOrder); err != nil {
+		return nil, err
+	}
+	return &repairOrder, nil
+}
+
+func (s *gormStore) GetRepairOrderByClaimForUpdate(claimUUID string) (*RepairOrder, error) {
+	var repairOrder RepairOrder
+	if err := first(forUpdate(s.db).Where("claim_uuid = ?", claimUUID), &repairOrder); err != nil {
+		return nil, err
+	}
+	return &repairOrder, nil
+}
+
+func (s *gormStore) ListRepairOrders(filter RepairOrderFilter) ([]RepairOrder, error) {
+	query := s.db.Model(&RepairOrder{})
+	if filter.Ready != nil {
+		query = query.Where("ready = ?", *filter.Ready)
+	}
+	if filter.ContractUUID != "" {
+		query = query.Where("contract_uuid = ?", filter.ContractUUID)
+	}
+
+	var repairOrders []RepairOrder
+	err := find(query, &repairOrders, repairOrderList, filter.ListOptions)
+	return repairOrders, err
+}
+
+func (s *gormStore) CreateRepairOrder(repairOrder *RepairOrder) error {
+	repairOrder.Version = 1
+	return create(s.db, repairOrder)
+}
+
+func (s *gormStore) SaveRepairOrder(repairOrder *RepairOrder) error {
+	return update(s.db, repairOrder, &repairOrder.Version, "uuid = ?", repairOrder.UUID)
+}
+
+// Audit log
+
+// auditLockID is the Postgres advisory lock serializing audit appends across
+// transactions, which cannot see each other's uncommitted entries.
+const auditLockID = 7342002
+
+// LastAuditEntry also takes the audit lock, which is held until the
+// enclosing transaction ends, so that the entry appended next links to the
+// latest one.
+func (s *gormStore) LastAuditEntry() (*AuditEntry, error) {
+	if err := s.db.Exec("SELECT pg_advisory_xact_lock(?)", auditLockID).Error; err != nil {
+		return nil, err
+	}
+
+	var entry AuditEntry
+	if err := first(s.db.Order("id DESC"), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *gormStore) AppendAuditEntry(entry *AuditEntry) error {
+	return create(s.db, entry)
+}
+
+func (s *gormStore) ListAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	query := s.db.Model(&AuditEntry{})
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+
+	var entries []AuditEntry
+	err := find(query, &entries, auditEntryList, filter.ListOptions)
+	return entries, err
+}
+
+func (s *gormStore) WalkAuditEntries(fn func(entry *AuditEntry) error) error {
+	var batch []AuditEntry
+	return s.db.Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// Contract tokens
+
+func (s *gormStore) GetToken(tokenID string) (*ContractToken, error) {
+	var token ContractToken
+	if err := first(s.db.Where("token_id = ?", tokenID), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *gormStore) GetTokenByContract(contractUUID string) (*ContractToken, error) {
+	var token ContractToken
+	if err := first(s.db.Where("contract_uuid = ?", contractUUID), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *gormStore) ListTokens(filter TokenFilter) ([]ContractToken, error) {
+	var tokens []ContractToken
+	err := find(s.db.Model(&ContractToken{}).Where("owner = ?", filter.Owner), &tokens, tokenList, filter.ListOptions)
+	return tokens, err
+}
+
+func (s *gormStore) CreateToken(token *ContractToken) error {
+	return create(s.db, token)
+}
+
+func (s *gormStore) SaveToken(token *ContractToken) error {
+	return s.db.Save(token).Error
+}
+
+// Contract transfers
+
+func (s *gormStore) GetTransfer(uuid string) (*ContractTransfer, error) {
+	var transfer ContractTransfer
+	if err := first(s.db.Where("uuid = ?", uuid), &transfer); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+func (s *gormStore) GetTransferForUpdate(uuid string) (*ContractTransfer, error) {
+	var transfer ContractTransfer
+	if err := first(forUpdate(s.db).Where("uuid = ?", uuid), &transfer); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+func (s *gormStore) ListTransfers(filter TransferFilter) ([]ContractTransfer, error) {
+	query := s.db.Model(&ContractTransfer{})
+	if filter.ContractUUID != "" {
+		query = query.Where("contract_uuid = ?", filter.ContractUUID)
+	}
+	if filter.Username != "" {
+		query = query.Where("(from_username = ? OR to_username = ?)", filter.Username, filter.Username)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var transfers []ContractTransfer
+	err := find(query, &transfers, transferList, filter.ListOptions)
+	return transfers, err
+}
+
+func (s *gormStore) CreateTransfer(transfer *ContractTransfer) error {
+	transfer.Version = 1
+	return create(s.db, transfer)
+}
+
+func (s *gormStore) SaveTransfer(transfer *ContractTransfer) error {
+	return update(s.db, transfer, &transfer.Version, "uuid = ?", transfer.UUID)
+}
+
+// Idempotency keys
+
+func (s *gormStore) GetIdempotencyRecord(username, key string) (*IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	if err := first(s.db.Where("username = ? AND key = ?", username, key), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *gormStore) CreateIdempotencyRecord(record *IdempotencyRecord) error {
+	return create(s.db, record)
+}
+
+func (s *gormStore) SaveIdempotencyRecord(record *IdempotencyRecord) error {
+	return s.db.Save(record).Error
+}
+
+func (s *gormStore) DeleteIdempotencyRecord(username, key string) error {
+	return s.db.Where("username = ? AND key = ?", username, key).Delete(&IdempotencyRecord{}).Error
+}
+
+func (s *gormStore) DeleteExpiredIdempotencyRecords(now time.Time) error {
+	return s.db.Where("expires_at <= ?", now).Delete(&IdempotencyRecord{}).Error
+}
+
+// Record numbers
+
+func (s *gormStore) NextNumber(prefix string, year int) (int64, error) {
+	// The counter row stays locked until the transaction ends, so numbers
+	// are issued in commit order without gaps
+	var value int64
+	err := s.db.Raw(`INSERT INTO number_sequences (prefix, year, value) VALUES (?, ?, 1)
+		ON CONFLICT (prefix, year) DO UPDATE SET value = number_sequences.value + 1
+		RETURNING value`, prefix, year).Scan(&value).Error
+	return value, err
+}
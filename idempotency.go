@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Clients may send an Idempotency-Key header with any request changing
+// data, so that it can be retried safely. The first request with a key runs
+// and its response is stored; retries with the same key and request replay
+// that response, marked with "Idempotent-Replayed: true", until the
+// idempotency_window has passed. Reusing a key for a different request is
+// rejected with 422, and retrying while the first request is still running
+// with 409. Server errors are not stored, so those requests run again.
+//
+// The request runs in the same store transaction that stores its response,
+// and the response is only sent once that has committed. A request whose
+// response cannot be stored is rolled back and fails with 500, so that a
+// retry neither replays nor repeats anything half done.
+//
+// A request holds its key for idempotencyLease before its response is
+// stored, so that a key whose request was cut short by the server stopping
+// can be used again once the lease has passed.
+//
+// Keys are scoped to the caller. Session routes ignore them, so that their
+// tokens are never stored.
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key accepted.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyWindow is how long responses are kept for replay. It is set
+// from the configuration at startup.
+var idempotencyWindow = 24 * time.Hour
+
+// idempotencyLease is how long a running request holds its key. It must be
+// longer than any request takes, or a retry may run alongside it.
+const idempotencyLease = time.Minute
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key. StatusCode is 0 while the request is still running, and
+// ExpiresAt then ends its lease on the key.
+type IdempotencyRecord struct {
+	Username    string    `gorm:"primaryKey"`
+	Key         string    `gorm:"primaryKey"`
+	RequestHash string    `gorm:"not null"`
+	StatusCode  int       `gorm:"not null"`
+	ContentType string    `gorm:"not null"`
+	ETag        string    `gorm:"column:etag;not null"`
+	Body        []byte    `gorm:"column:body"`
+	CreatedAt   time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null"`
+}
+
+// txKey carries the store transaction an idempotent request runs in.
+const txKey contextKey = "tx"
+
+// storeFromContext returns the transaction the request runs in, or store if
+// it runs in none.
+func storeFromContext(ctx context.Context, store Store) Store {
+	if tx, ok := ctx.Value(txKey).(Store); ok {
+		return tx
+	}
+	return store
+}
+
+// responseRecorder holds back a response until send is called. Headers go
+// straight to the underlying ResponseWriter, which sends them with the
+// status.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+// send writes the recorded response.
+func (r *responseRecorder) send() {
+	r.ResponseWriter.WriteHeader(r.status)
+	r.ResponseWriter.Write(r.body.Bytes())
+}
+
+// idempotent honors the Idempotency-Key header on route, unless it is a
+// GET route, which changes nothing. It must run after requireSession.
+func idempotent(store Store, route string, next http.HandlerFunc) http.HandlerFunc {
+	if strings.HasPrefix(route, http.MethodGet+" ") {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, validationError("invalid_idempotency_key", "Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		// Read the body for the request hash, then hand it on
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, validationError("unreadable_body", "failed to read request body: %v", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		username := callerFromContext(r.Context()).Username
+		record, replay, err := claimIdempotencyKey(store, username, key, requestHash(r, body))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if replay {
+			replayResponse(w, record)
+			return
+		}
+
+		// Run the request and store its response in one transaction. Server
+		// errors are not stored, so that they can be retried
+		recorder := &responseRecorder{ResponseWriter: w}
+		err = store.Transaction(func(tx Store) error {
+			next(recorder, r.WithContext(context.WithValue(r.Context(), txKey, tx)))
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			if recorder.status >= http.StatusInternalServerError {
+				return nil
+			}
+			record.StatusCode = recorder.status
+			record.ContentType = w.Header().Get("Content-Type")
+			record.ETag = w.Header().Get("ETag")
+			record.Body = recorder.body.Bytes()
+			record.ExpiresAt = time.Now().UTC().Truncate(time.Microsecond).Add(idempotencyWindow)
+			return tx.SaveIdempotencyRecord(record)
+		})
+		if err != nil {
+			releaseIdempotencyKey(store, username, key)
+			w.Header().Del("ETag")
+			writeError(w, fmt.Errorf("failed to store response for idempotency key %q: %w", key, err))
+			return
+		}
+		if recorder.status >= http.StatusInternalServerError {
+			releaseIdempotencyKey(store, username, key)
+		}
+		recorder.send()
+	}
+}
+
+// releaseIdempotencyKey deletes the record of a request that left nothing
+// to replay, so that the key can be used again at once.
+func releaseIdempotencyKey(store Store, username, key string) {
+	if err := store.DeleteIdempotencyRecord(username, key); err != nil {
+		log.Printf("Failed to release idempotency key %q: %v", key, err)
+	}
+}
+
+// requestHash identifies what a request asks for: its method, path, query,
+// If-Match header and body.
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s?%s\n%s\n", r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("If-Match"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// claimIdempotencyKey records that the request with key is running, or
+// returns the record of the earlier request with the key to replay.
+func claimIdempotencyKey(store Store, username, key, hash string) (*IdempotencyRecord, bool, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	record := &IdempotencyRecord{
+		Username:    username,
+		Key:         key,
+		RequestHash: hash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(idempotencyLease),
+	}
+
+	// A second attempt follows if the key's earlier record or lease expired
+	// or was released meanwhile
+	for attempt := 0; attempt < 2; attempt++ {
+		err := store.CreateIdempotencyRecord(record)
+		if err == nil {
+			return record, false, nil
+		}
+		if !errors.Is(err, ErrDuplicate) {
+			return nil, false, fmt.Errorf("failed to record idempotency key: %w", err)
+		}
+
+		existing, err := store.GetIdempotencyRecord(username, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, false, fmt.Errorf("failed to fetch idempotency key: %w", err)
+		}
+
+		switch {
+		case !now.Before(existing.ExpiresAt):
+			if err := store.DeleteExpiredIdempotencyRecords(now); err != nil {
+				return nil, false, fmt.Errorf("failed to expire idempotency keys: %w", err)
+			}
+			continue
+		case existing.RequestHash != hash:
+			return nil, false, unprocessableError("idempotency_key_reused", "Idempotency-Key %q was already used for a different request", key)
+		case existing.StatusCode == 0:
+			return nil, false, conflictError("idempotency_key_in_use", "a request with Idempotency-Key %q is still in progress", key)
+		default:
+			return existing, true, nil
+		}
+	}
+	return nil, false, conflictError("idempotency_key_in_use", "a request with Idempotency-Key %q is still in progress", key)
+}
+
+// replayResponse writes the stored response of an earlier request.
+func replayResponse(w http.ResponseWriter, record *IdempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	if record.ETag != "" {
+		w.Header().Set("ETag", record.ETag)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// purgeIdempotencyRecords deletes expired idempotency records every
+// interval, for as long as the server runs.
+func purgeIdempotencyRecords(store Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := store.DeleteExpiredIdempotencyRecords(time.Now().UTC()); err != nil {
+			log.Printf("Failed to purge idempotency records: %v", err)
+		}
+	}
+}
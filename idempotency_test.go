@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idempotencyTest runs an idempotent route whose handler counts its calls.
+type idempotencyTest struct {
+	store   *memoryStore
+	handler http.HandlerFunc
+	calls   int
+	status  int
+}
+
+func newIdempotencyTest() *idempotencyTest {
+	test := &idempotencyTest{store: newMemoryStore(), status: http.StatusOK}
+	test.handler = idempotent(test.store, "POST /things", func(w http.ResponseWriter, r *http.Request) {
+		test.calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"1"`)
+		w.WriteHeader(test.status)
+		w.Write([]byte(`{"echo":` + string(body) + `}`))
+	})
+	return test
+}
+
+func (test *idempotencyTest) do(username, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	test.handler(w, newIdempotentRequest(username, key, body))
+	return w
+}
+
+func newIdempotentRequest(username, key, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	return r.WithContext(context.WithValue(r.Context(), callerKey, &User{Username: username}))
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	test := newIdempotencyTest()
+	test.status = http.StatusCreated
+
+	first := test.do("alice", "key-1", `{"n":1}`)
+	second := test.do("alice", "key-1", `{"n":1}`)
+
+	if test.calls != 1 {
+		t.Fatalf("handler ran %d times, want once", test.calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replayed %d %s, want %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("only the replayed response should be marked Idempotent-Replayed")
+	}
+	if second.Header().Get("ETag") != `"1"` || second.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("replayed headers %v, want the ETag and Content-Type of the first response", second.Header())
+	}
+}
+
+func TestIdempotencyKeyRejections(t *testing.T) {
+	tests := []struct {
+		name       string
+		run        func(test *idempotencyTest) *httptest.ResponseRecorder
+		wantStatus int
+		wantCode   string
+		wantCalls  int
+	}{
+		{
+			name: "key reused for a different request",
+			run: func(test *idempotencyTest) *httptest.ResponseRecorder {
+				test.do("alice", "key-1", `{"n":1}`)
+				return test.do("alice", "key-1", `{"n":2}`)
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   "idempotency_key_reused",
+			wantCalls:  1,
+		},
+		{
+			name: "retry while the first request runs",
+			run: func(test *idempotencyTest) *httptest.ResponseRecorder {
+				hash := requestHash(newIdempotentRequest("alice", "key-1", ""), []byte(`{"n":1}`))
+				claimIdempotencyKey(test.store, "alice", "key-1", hash)
+				return test.do("alice", "key-1", `{"n":1}`)
+			},
+			wantStatus: http.StatusConflict,
+			wantCode:   "idempotency_key_in_use",
+			wantCalls:  0,
+		},
+		{
+			name: "key too long",
+			run: func(test *idempotencyTest) *httptest.ResponseRecorder {
+				return test.do("alice", strings.Repeat("k", maxIdempotencyKeyLength+1), `{"n":1}`)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "invalid_idempotency_key",
+			wantCalls:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newIdempotencyTest()
+			w := tt.run(test)
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("response %d %s, want %d %s", w.Code, w.Body, tt.wantStatus, tt.wantCode)
+			}
+			if test.calls != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", test.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotentRequestsThatRunAgain(t *testing.T) {
+	tests := []struct {
+		name  string
+		first func(t *testing.T, test *idempotencyTest)
+	}{
+		{
+			name:  "without a key",
+			first: func(t *testing.T, test *idempotencyTest) { test.do("alice", "", `{"n":1}`) },
+		},
+		{
+			name:  "key of another caller",
+			first: func(t *testing.T, test *idempotencyTest) { test.do("bob", "key-1", `{"n":1}`) },
+		},
+		{
+			name: "after a server error",
+			first: func(t *testing.T, test *idempotencyTest) {
+				test.status = http.StatusInternalServerError
+				test.do("alice", "key-1", `{"n":1}`)
+				test.status = http.StatusOK
+			},
+		},
+		{
+			name: "after the window",
+			first: func(t *testing.T, test *idempotencyTest) {
+				test.do("alice", "key-1", `{"n":1}`)
+				expireIdempotencyRecord(t, test.store, "alice", "key-1")
+			},
+		},
+		{
+			name: "after the lease of a request that never finished",
+			first: func(t *testing.T, test *idempotencyTest) {
+				if _, _, err := claimIdempotencyKey(test.store, "alice", "key-1", "interrupted request"); err != nil {
+					t.Fatal(err)
+				}
+				expireIdempotencyRecord(t, test.store, "alice", "key-1")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newIdempotencyTest()
+			tt.first(t, test)
+			calls := test.calls
+
+			w := test.do("alice", "key-1", `{"n":1}`)
+			if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+				t.Errorf("response %d %s, want the request to run", w.Code, w.Body)
+			}
+			if test.calls != calls+1 {
+				t.Errorf("handler ran %d times, want %d", test.calls, calls+1)
+			}
+		})
+	}
+}
+
+func TestIdempotencyLease(t *testing.T) {
+	store := newMemoryStore()
+	record, replay, err := claimIdempotencyKey(store, "alice", "key-1", "hash")
+	if err != nil || replay {
+		t.Fatalf("claimIdempotencyKey = %v, %v", replay, err)
+	}
+	if lease := record.ExpiresAt.Sub(record.CreatedAt); lease != idempotencyLease {
+		t.Errorf("running request holds its key for %s, want %s", lease, idempotencyLease)
+	}
+
+	test := newIdempotencyTest()
+	before := time.Now()
+	test.do("alice", "key-1", `{"n":1}`)
+	stored, err := test.store.GetIdempotencyRecord("alice", "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ExpiresAt.Before(before.Add(idempotencyWindow - time.Second)) {
+		t.Errorf("response kept until %s, want the idempotency window from now", stored.ExpiresAt)
+	}
+}
+
+// expireIdempotencyRecord moves the expiry of a record into the past.
+func expireIdempotencyRecord(t *testing.T, store Store, username, key string) {
+	t.Helper()
+	record, err := store.GetIdempotencyRecord(username, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.ExpiresAt = time.Now().Add(-time.Second)
+	if err := store.SaveIdempotencyRecord(record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingSaveStore fails to store responses for idempotency keys.
+type failingSaveStore struct {
+	*memoryStore
+}
+
+func (s failingSaveStore) Transaction(fn func(tx Store) error) error {
+	return s.memoryStore.Transaction(func(tx Store) error {
+		return fn(failingSaveStore{tx.(*memoryStore)})
+	})
+}
+
+func (s failingSaveStore) SaveIdempotencyRecord(record *IdempotencyRecord) error {
+	return errors.New("disk full")
+}
+
+func TestIdempotentResponseNotStored(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	handler := idempotent(failingSaveStore{store}, "POST /users", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		tx := storeFromContext(r.Context(), store)
+		if err := tx.CreateUser(&User{Username: fmt.Sprintf("user-%d", calls)}); err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		w := httptest.NewRecorder()
+		handler(w, newIdempotentRequest("alice", "key-1", `{}`))
+		if w.Code != http.StatusInternalServerError || w.Header().Get("ETag") != "" {
+			t.Errorf("attempt %d: %d with ETag %q, want 500 without", attempt, w.Code, w.Header().Get("ETag"))
+		}
+		if _, err := store.GetUser(fmt.Sprintf("user-%d", attempt)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("attempt %d: user kept although the response was not stored: %v", attempt, err)
+		}
+		if _, err := store.GetIdempotencyRecord("alice", "key-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("attempt %d: key still held: %v", attempt, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
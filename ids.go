@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Records are identified by UUIDs the server generates. Contracts and
+// claims also get a number for customer communication, such as
+// POL-2026-000123: the prefix, the year it was issued and a sequence that
+// restarts every year.
+
+const (
+	policyNumberPrefix = "POL"
+	claimNumberPrefix  = "CLM"
+)
+
+// numberLocation is the time zone whose calendar year numbers are issued
+// in. It is set from the configuration at startup.
+var numberLocation = time.UTC
+
+// newUUID returns a random (version 4) UUID for a new record.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Fatalf("Failed to generate UUID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// nextNumber issues the next number with prefix for a record created at
+// now, in the year now falls in at numberLocation. It is taken in the
+// store's transaction, so a failed creation leaves no gap.
+func nextNumber(store Store, prefix string, now time.Time) (string, error) {
+	year := now.In(numberLocation).Year()
+	n, err := store.NextNumber(prefix, year)
+	if err != nil {
+		return "", fmt.Errorf("failed to issue %s number: %w", prefix, err)
+	}
+	return fmt.Sprintf("%s-%d-%06d", prefix, year, n), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNextNumber(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(location *time.Location) { numberLocation = location }(numberLocation)
+	numberLocation = shanghai
+
+	// 2026-12-31 20:00 UTC is already New Year's Day in Shanghai
+	lastDay := time.Date(2026, 12, 31, 12, 0, 0, 0, time.UTC)
+	newYear := time.Date(2026, 12, 31, 20, 0, 0, 0, time.UTC)
+
+	store := newMemoryStore()
+	issue := []struct {
+		prefix string
+		now    time.Time
+	}{
+		{prefix: policyNumberPrefix, now: lastDay},
+		{prefix: policyNumberPrefix, now: lastDay},
+		{prefix: claimNumberPrefix, now: lastDay},
+		{prefix: policyNumberPrefix, now: newYear},
+		{prefix: policyNumberPrefix, now: lastDay},
+		{prefix: claimNumberPrefix, now: newYear},
+	}
+	want := []string{
+		"POL-2026-000001",
+		"POL-2026-000002",
+		"CLM-2026-000001",
+		"POL-2027-000001",
+		"POL-2026-000003",
+		"CLM-2027-000001",
+	}
+
+	var got []string
+	for _, i := range issue {
+		number, err := nextNumber(store, i.prefix, i.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, number)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("issued %v, want %v", got, want)
+	}
+}
+
+func TestNextNumberPadding(t *testing.T) {
+	store := newMemoryStore()
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	store.numbers["POL-2026"] = 999998
+
+	for _, want := range []string{"POL-2026-999999", "POL-2026-1000000"} {
+		number, err := nextNumber(store, policyNumberPrefix, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if number != want {
+			t.Errorf("nextNumber = %s, want %s", number, want)
+		}
+	}
+}
@@ -1,389 +1,435 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"slices"
 	"time"
 
-	"gorm.io/gorm"
-
 	//"golang.org/x/crypto/bcrypt"
 
 )
 
-func listContractTypes(db *gorm.DB, args string) ([]ContractType, error) {
-	// Check if the request is from a merchant
-	callingAsMerchant := len(args) > 0
-	var input struct {
-		ShopType string `json:"shop_type"`
-	}
-
-	// Parse input arguments for merchant filtering
-	if callingAsMerchant {
-		if err := json.Unmarshal([]byte(args), &input); err != nil {
-			return nil, fmt.Errorf("invalid input: %v", err)
-		}
-	}
+// ListContractTypesRequest filters and pages contract types.
+type ListContractTypesRequest struct {
+	PageRequest
+	ShopType string `json:"shop_type"`
+	Active   *bool  `json:"active"`
+}
 
-	// Query contract types
-	var contractTypes []ContractType
-	query := db.Model(&ContractType{})
+func listContractTypes(store Store, caller *User, input *ListContractTypesRequest) (*Page[ContractType], error) {
+	filter := ContractTypeFilter{ShopType: input.ShopType, Active: input.Active}
 
-	// Apply filtering if the request is from a merchant
-	if callingAsMerchant {
-		query = query.Where("active = ? AND shop_type ILIKE ?", true, "%"+strings.ToTitle(input.ShopType)+"%")
+	// Merchants only see active contract types
+	if caller.Role == RoleMerchant {
+		active := true
+		filter.Active = &active
 	}
 
-	// Execute the query
-	if err := query.Find(&contractTypes).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch contract types: %v", err)
+	// Query contract types
+	page, err := listPage(input.PageRequest, contractTypeList, func(opts ListOptions) ([]ContractType, error) {
+		filter.ListOptions = opts
+		return store.ListContractTypes(filter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract types: %w", err)
 	}
 
-	return contractTypes, nil
+	return page, nil
 }
 
+// CreateContractTypeRequest defines a new contract type. Its UUID and
+// version are assigned by the server.
+type CreateContractTypeRequest struct {
+	ShopType          string  `json:"shop_type" validate:"required"`
+	FormulaPerDay     string  `json:"formula_per_day" validate:"required"`
+	MaxSumInsured     float32 `json:"max_sum_insured" validate:"min=0"`
+	TheftInsured      bool    `json:"theft_insured"`
+	Description       string  `json:"description"`
+	Conditions        string  `json:"conditions"`
+	Active            bool    `json:"active"`
+	MinDurationDays   int32   `json:"min_duration_days" validate:"min=0"`
+	MaxDurationDays   int32   `json:"max_duration_days" validate:"min=0"`
+	WaitingPeriodDays int32   `json:"waiting_period_days" validate:"min=0"`
+	Transferable      *bool   `json:"transferable,omitempty"`
+}
 
-func createContractType(db *gorm.DB, args string) error {
-	// Parse input
-	var partial struct {
-		UUID string `json:"uuid"`
+// Validate checks the duration bounds against each other, which the
+// validate tags cannot express.
+func (r *CreateContractTypeRequest) Validate() error {
+	if r.MaxDurationDays == 0 || r.MaxDurationDays >= r.MinDurationDays {
+		return nil
 	}
-	var contractType ContractType
+	return fieldErrors(FieldError{Field: "max_duration_days", Reason: "must be at least min_duration_days"})
+}
 
-	// Extract UUID
-	if err := json.Unmarshal([]byte(args), &partial); err != nil {
-		return fmt.Errorf("invalid input: %v", err)
+func createContractType(store Store, caller *User, input *CreateContractTypeRequest) (*ContractType, error) {
+	contractType := &ContractType{
+		UUID:              newUUID(),
+		ShopType:          input.ShopType,
+		FormulaPerDay:     input.FormulaPerDay,
+		MaxSumInsured:     input.MaxSumInsured,
+		TheftInsured:      input.TheftInsured,
+		Description:       input.Description,
+		Conditions:        input.Conditions,
+		Active:            input.Active,
+		MinDurationDays:   input.MinDurationDays,
+		MaxDurationDays:   input.MaxDurationDays,
+		WaitingPeriodDays: input.WaitingPeriodDays,
+		Transferable:      input.Transferable,
 	}
 
-	// Parse full contract type
-	if err := json.Unmarshal([]byte(args), &contractType); err != nil {
-		return fmt.Errorf("invalid input: %v", err)
+	// Validate the premium formula
+	if err := validateFormula(*contractType); err != nil {
+		return nil, validationError("invalid_formula", "invalid formula_per_day: %v", err)
 	}
 
-	// Assign UUID to the contract type
-	contractType.UUID = partial.UUID
-
 	// Save to the database
-	if err := db.Create(&contractType).Error; err != nil {
-		return fmt.Errorf("failed to create contract type: %v", err)
+	if err := store.CreateContractType(contractType); err != nil {
+		return nil, fmt.Errorf("failed to create contract type: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "contract_type.create", "contract_type", contractType.UUID, nil, contractType); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return contractType, nil
 }
 
-func setActiveContractType(db *gorm.DB, args string) error {
-	// Parse input
-	var input struct {
-		UUID   string `json:"uuid"`
-		Active bool   `json:"active"`
-	}
+// ContractTypeRequest identifies a contract type.
+type ContractTypeRequest struct {
+	UUID string `json:"uuid" validate:"required"`
+}
 
-	if err := json.Unmarshal([]byte(args), &input); err != nil {
-		return fmt.Errorf("invalid input: %v", err)
+// getContractType returns one contract type, with its version as ETag.
+// Merchants only see active contract types.
+func getContractType(store Store, caller *User, input *ContractTypeRequest) (*ContractType, error) {
+	contractType, err := store.GetContractType(input.UUID)
+	if err == nil && caller.Role == RoleMerchant && !contractType.Active {
+		err = ErrNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("contract_type_not_found", "contract type with UUID %s not found", input.UUID)
+		}
+		return nil, fmt.Errorf("failed to query contract type: %w", err)
 	}
+	return contractType, nil
+}
+
+// SetActiveContractTypeRequest enables or disables a contract type.
+type SetActiveContractTypeRequest struct {
+	IfMatch
+	UUID   string `json:"uuid" validate:"required"`
+	Active bool   `json:"active"`
+}
 
+func setActiveContractType(store Store, caller *User, input *SetActiveContractTypeRequest) (*ContractType, error) {
 	// Fetch the contract type
-	var contractType ContractType
-	if err := db.Where("uuid = ?", input.UUID).First(&contractType).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("contract type with UUID %s not found", input.UUID)
+	contractType, err := store.GetContractType(input.UUID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("contract_type_not_found", "contract type with UUID %s not found", input.UUID)
 		}
-		return fmt.Errorf("failed to query contract type: %v", err)
+		return nil, fmt.Errorf("failed to query contract type: %w", err)
+	}
+	if err := input.check(contractType.Version); err != nil {
+		return nil, err
 	}
 
 	// Update the active status
+	before := *contractType
 	contractType.Active = input.Active
-	if err := db.Save(&contractType).Error; err != nil {
-		return fmt.Errorf("failed to update contract type: %v", err)
+	if err := store.SaveContractType(contractType); err != nil {
+		return nil, fmt.Errorf("failed to update contract type: %w", err)
 	}
 
-	return nil
+	if err := recordAudit(store, caller.Username, "contract_type.set_active", "contract_type", contractType.UUID, before, contractType); err != nil {
+		return nil, err
+	}
+	return contractType, nil
 }
 
 
-func listContracts(db *gorm.DB, args string) ([]Contract, error) {
-	// Parse input arguments for optional username filtering
-	var input struct {
-		Username string `json:"username"`
-	}
-	if len(args) > 0 {
-		if err := json.Unmarshal([]byte(args), &input); err != nil {
-			return nil, fmt.Errorf("invalid input: %v", err)
-		}
+// ListContractsRequest filters and pages contracts. Number finds a contract
+// by its policy number. Start dates match from StartDateFrom inclusive to
+// StartDateTo exclusive.
+type ListContractsRequest struct {
+	PageRequest
+	Number           string    `json:"number"`
+	Username         string    `json:"username"`
+	ContractTypeUUID string    `json:"contract_type_uuid"`
+	Void             *bool     `json:"void"`
+	StartDateFrom    time.Time `json:"start_date_from"`
+	StartDateTo      time.Time `json:"start_date_to" validate:"after=start_date_from"`
+}
+
+func listContracts(store Store, caller *User, input *ListContractsRequest) (*Page[Contract], error) {
+	// Customers only ever see their own contracts
+	if caller.Role == RoleCustomer {
+		input.Username = caller.Username
 	}
-	filterByUsername := len(input.Username) > 0
 
 	// Query contracts with claims preloaded
-	var contracts []Contract
-	query := db.Model(&Contract{}).Preload("Claims")
-	if filterByUsername {
-		query = query.Where("username = ?", input.Username)
+	filter := ContractFilter{
+		Number:           input.Number,
+		Username:         input.Username,
+		ContractTypeUUID: input.ContractTypeUUID,
+		Void:             input.Void,
+		StartFrom:        input.StartDateFrom,
+		StartTo:          input.StartDateTo,
+	}
+	page, err := listPage(input.PageRequest, contractList, func(opts ListOptions) ([]Contract, error) {
+		filter.ListOptions = opts
+		return store.ListContracts(filter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contracts: %w", err)
 	}
 
-	// Execute the query
-	if err := query.Find(&contracts).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch contracts: %v", err)
+	// A transferred contract keeps its claims, but customers only see the
+	// ones they filed themselves
+	if caller.Role == RoleCustomer {
+		for i := range page.Items {
+			page.Items[i].Claims = slices.DeleteFunc(page.Items[i].Claims, func(c Claim) bool {
+				return c.FiledBy != caller.Username
+			})
+		}
 	}
 
 	// Return the contracts with preloaded claims
-	return contracts, nil
+	return page, nil
 }
 
 
-func listClaims(db *gorm.DB, args string) ([]Claim, error) {
-	// Parse input arguments for optional status filtering
-	var input struct {
-		Status ClaimStatus `json:"status"`
-	}
-	if len(args) > 0 {
-		if err := json.Unmarshal([]byte(args), &input); err != nil {
-			return nil, fmt.Errorf("invalid input: %v", err)
-		}
-	}
-
-	// Query claims with optional status filtering
-	var claims []Claim
-	query := db.Model(&Claim{})
-	if input.Status != ClaimStatusUnknown {
-		query = query.Where("status = ?", input.Status)
-	}
+// ListClaimsRequest filters and pages claims. Number finds a claim by its
+// claim number. Claim dates match from DateFrom inclusive to DateTo
+// exclusive.
+type ListClaimsRequest struct {
+	PageRequest
+	Number       string      `json:"number"`
+	Status       ClaimStatus `json:"status"`
+	ContractUUID string      `json:"contract_uuid"`
+	IsTheft      *bool       `json:"is_theft"`
+	DateFrom     time.Time   `json:"date_from"`
+	DateTo       time.Time   `json:"date_to" validate:"after=date_from"`
+}
 
-	if err := query.Find(&claims).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch claims: %v", err)
+func listClaims(store Store, caller *User, input *ListClaimsRequest) (*Page[Claim], error) {
+	// Query claims with optional filtering
+	filter := ClaimFilter{
+		Number:       input.Number,
+		ContractUUID: input.ContractUUID,
+		Status:       input.Status,
+		IsTheft:      input.IsTheft,
+		DateFrom:     input.DateFrom,
+		DateTo:       input.DateTo,
+	}
+	page, err := listPage(input.PageRequest, claimList, func(opts ListOptions) ([]Claim, error) {
+		filter.ListOptions = opts
+		return store.ListClaims(filter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch claims: %w", err)
 	}
 
-	return claims, nil
+	return page, nil
 }
 
 
-func fileClaim(db *gorm.DB, args string) error {
-	// Parse input arguments
-	var dto struct {
-		UUID         string    `json:"uuid"`
-		ContractUUID string    `json:"contract_uuid"`
-		Date         time.Time `json:"date"`
-		Description  string    `json:"description"`
-		IsTheft      bool      `json:"is_theft"`
-	}
-	if err := json.Unmarshal([]byte(args), &dto); err != nil {
-		return fmt.Errorf("invalid input: %v", err)
-	}
+// FileClaimRequest files a claim against one of the caller's contracts.
+type FileClaimRequest struct {
+	ContractUUID string    `json:"contract_uuid" validate:"required"`
+	Date         time.Time `json:"date" validate:"required"`
+	Description  string    `json:"description" validate:"required"`
+	IsTheft      bool      `json:"is_theft"`
+}
 
+func fileClaim(store Store, caller *User, dto *FileClaimRequest) (*Claim, error) {
 	
 	// Create the claim
 	claim := Claim{
-		UUID:         dto.UUID,
+		UUID:         newUUID(),
 		ContractUUID: dto.ContractUUID,
+		FiledBy:      caller.Username,
 		Date:         dto.Date,
 		Description:  dto.Description,
 		IsTheft:      dto.IsTheft,
 		Status:       ClaimStatusNew,
 	}
 
-	// Check if the contract exists
-	var contract Contract
-	if err := db.Where("uuid = ?", dto.ContractUUID).First(&claim).Error; err != nil {  //was First(&contract) 
-		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("contract not found: %s", dto.ContractUUID)
+	// Check if the contract exists and belongs to the caller, locking it
+	// so that it cannot be transferred while the claim is filed
+	contract, err := store.GetContractForUpdate(dto.ContractUUID)
+	if err == nil && contract.Username != caller.Username {
+		err = ErrNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("contract_not_found", "contract not found: %s", dto.ContractUUID)
 		}
-		return fmt.Errorf("failed to fetch contract: %v", err)
+		return nil, fmt.Errorf("failed to fetch contract: %w", err)
 	}
 
+	// Check the claim against the contract and its coverage
+	contractType, err := store.GetContractType(contract.ContractTypeUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract type: %w", err)
+	}
+	if err := checkClaimIntake(&claim, contract, contractType); err != nil {
+		return nil, err
+	}
 
 	// Save the claim to the database
-	if err := db.Create(&claim).Error; err != nil {
-		return fmt.Errorf("failed to file claim: %v", err)
+	claim.Number, err = nextNumber(store, claimNumberPrefix, time.Now())
+	if err != nil {
+		return nil, err
 	}
-
-	// Update the claim index in the contract (if needed)
-	contract.ClaimIndex = append(contract.ClaimIndex, claim.UUID)
-	if err := db.Save(&contract).Error; err != nil {
-		return fmt.Errorf("failed to update contract claim index: %v", err)
+	if err := store.CreateClaim(&claim); err != nil {
+		return nil, fmt.Errorf("failed to file claim: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "claim.file", "claim", claim.UUID, nil, claim); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &claim, nil
 }
 
 
-func processClaim(db *gorm.DB, args string) error {
-	// Parse input arguments
-	var input struct {
-		UUID         string              `json:"uuid"`
-		ContractUUID string              `json:"contract_uuid"`
-		Status       ClaimStatus  `json:"status"`
-		Reimbursable float32             `json:"reimbursable"`
-	}
-	if err := json.Unmarshal([]byte(args), &input); err != nil {
-		return fmt.Errorf("invalid input: %v", err)
-	}
-
-	// Fetch the claim
-	var claim Claim
-	if err := db.Where("uuid = ? AND contract_uuid = ?", input.UUID, input.ContractUUID).First(&claim).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("claim not found for UUID: %s", input.UUID)
+// getClaim returns one claim, with its version as ETag.
+func getClaim(store Store, caller *User, input *ClaimRequest) (*Claim, error) {
+	claim, err := store.GetClaim(input.UUID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("claim_not_found", "claim not found for UUID: %s", input.UUID)
 		}
-		return fmt.Errorf("failed to fetch claim: %v", err)
-	}
-
-	// Validation logic
-	if !claim.IsTheft && claim.Status != ClaimStatusNew {
-		return errors.New("cannot change the status of a non-new claim")
-	}
-	if claim.IsTheft && claim.Status == ClaimStatusNew {
-		return errors.New("theft must first be confirmed by authorities")
+		return nil, fmt.Errorf("failed to fetch claim: %w", err)
 	}
+	return claim, nil
+}
 
-	// Update the claim status
-	claim.Status = input.Status
-	switch input.Status {
-	case ClaimStatusRepair:
-		// Approve repair
-		if claim.IsTheft {
-			return errors.New("cannot repair stolen items")
-		}
-		claim.Reimbursable = 0
-
-		// Create a repair order
-		var contract Contract
-		if err := db.Where("uuid = ?", input.ContractUUID).First(&claim).Error; err != nil {  //was First(&contract)
-			return fmt.Errorf("contract not found for UUID: %s", input.ContractUUID)
-		}
+// ProcessClaimRequest applies an action, or the action leading to a status,
+// to a claim.
+type ProcessClaimRequest struct {
+	IfMatch
+	UUID         string      `json:"uuid" validate:"required"`
+	ContractUUID string      `json:"contract_uuid"`
+	Action       ClaimAction `json:"action"`
+	Status       ClaimStatus `json:"status"`
+	Reimbursable float32     `json:"reimbursable" validate:"min=0"`
+}
 
-		repairOrder := RepairOrder{
-			Item:         contract.Item,
-			ClaimUUID:    input.UUID,
-			ContractUUID: input.ContractUUID,
-			Ready:        false,
-		}
-		if err := db.Create(&repairOrder).Error; err != nil {
-			return fmt.Errorf("failed to create repair order: %v", err)
+func processClaim(store Store, caller *User, input *ProcessClaimRequest) (*Claim, error) {
+	// Fetch and lock the claim, so that concurrent adjusters wait for each
+	// other and see each other's transitions
+	claim, err := store.GetClaimForUpdate(input.UUID)
+	if err == nil && input.ContractUUID != "" && claim.ContractUUID != input.ContractUUID {
+		err = ErrNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("claim_not_found", "claim not found for UUID: %s", input.UUID)
 		}
+		return nil, fmt.Errorf("failed to fetch claim: %w", err)
+	}
+	if err := input.check(claim.Version); err != nil {
+		return nil, err
+	}
 
-	case ClaimStatusReimbursement:
-		// Approve reimbursement
-		claim.Reimbursable = input.Reimbursable
-		if claim.IsTheft {
-			// Fetch and void the associated contract
-			var contract Contract
-			if err := db.Where("uuid = ?", input.ContractUUID).First(&claim).Error; err != nil { //was First(&contract)
-				return fmt.Errorf("contract not found for UUID: %s", input.ContractUUID)
-			}
-			contract.Void = true
-			if err := db.Save(&contract).Error; err != nil {
-				return fmt.Errorf("failed to update contract: %v", err)
-			}
+	// Resolve the requested status to an action if none was given
+	action := input.Action
+	if action == "" {
+		action, err = claimActionForStatus(caller, claim, input.Status)
+		if err != nil {
+			return nil, err
 		}
-
-	case ClaimStatusRejected:
-		// Mark the claim as rejected
-		claim.Reimbursable = 0
-
-	default:
-		return errors.New("unknown status change")
 	}
 
-	// Save the updated claim
-	if err := db.Save(&claim).Error; err != nil {
-		return fmt.Errorf("failed to update claim: %v", err)
+	err = applyClaimTransition(store, caller, claim, action, claimTransitionParams{
+		Reimbursable: input.Reimbursable,
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return nil
+	return claim, nil
 }
 
 
 
-func authUser(db *gorm.DB, args string) (bool, error) {
-	// Parse input arguments
-	var input struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}
-	if err := json.Unmarshal([]byte(args), &input); err != nil {
-		return false, fmt.Errorf("invalid input: %v", err)
-	}
+// AuthenticateRequest holds the credentials exchanged for a session.
+type AuthenticateRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
 
+func authUser(store Store, caller *User, input *AuthenticateRequest) (*Session, error) {
 	// Fetch the user from the database
-	var user User
-	if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return false, nil // User not found, authentication fails
+	user, err := store.GetUser(input.Username)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, unauthorizedError("invalid_credentials", "invalid username or password")
 		}
-		return false, fmt.Errorf("failed to fetch user: %v", err)
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
 	}
 
 	// Verify the password
-	authenticated := user.Password == input.Password
+	if !CheckPassword(user.Password, input.Password) {
+		return nil, unauthorizedError("invalid_credentials", "invalid username or password")
+	}
 
-	return authenticated, nil
+	// Issue a session for the authenticated user
+	return issueSession(user.Username)
 }
 
 
-func getUser(db *gorm.DB, args string) (map[string]string, error) {
-	// Parse input arguments
-	var input struct {
-		Username string `json:"username"`
-	}
-	if err := json.Unmarshal([]byte(args), &input); err != nil {
-		return nil, fmt.Errorf("invalid input: %v", err)
-	}
-
-	// Fetch the user from the database
-	var user User
-	if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, nil // User not found, return nil map
-		}
-		return nil, fmt.Errorf("failed to fetch user: %v", err)
-	}
+// UserInfo describes the authenticated user.
+type UserInfo struct {
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Role      Role   `json:"role"`
+	ShopType  string `json:"shop_type,omitempty"`
+}
 
-	// Construct the response
-	response := map[string]string{
-		"username":  user.Username,
-		"first_name": user.FirstName,
-		"last_name":  user.LastName,
+func getUser(store Store, caller *User, input *EmptyRequest) (*UserInfo, error) {
+	// Construct the response from the authenticated caller
+	response := &UserInfo{
+		Username:  caller.Username,
+		FirstName: caller.FirstName,
+		LastName:  caller.LastName,
+		Role:      caller.Role,
+		ShopType:  caller.ShopType,
 	}
 
 	return response, nil
 }
 
 
-// UpdatePassword updates a user's password based on their username.
-func updatePassword(db *gorm.DB, args string) (bool, error) {
-	// Parse input arguments
-	var input struct {
-		Username    string `json:"username"`
-		NewPassword string `json:"new_password"`
-	}
-	if err := json.Unmarshal([]byte(args), &input); err != nil {
-		return false, fmt.Errorf("invalid input: %v", err)
-	}
+// UpdatePasswordRequest sets a new password for the caller.
+type UpdatePasswordRequest struct {
+	NewPassword string `json:"new_password" validate:"required"`
+}
 
+// UpdatePassword updates the authenticated user's password.
+func updatePassword(store Store, caller *User, input *UpdatePasswordRequest) (bool, error) {
 	// Validate input
-	if input.Username == "" || input.NewPassword == "" {
-		return false, errors.New("username and new password must not be empty")
-	}
-
-	// Fetch the user from the database
-	var user User
-	if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return false, fmt.Errorf("user not found")
-		}
-		return false, fmt.Errorf("failed to fetch user: %v", err)
+	if input.NewPassword == "" {
+		return false, validationError("password_required", "new password must not be empty")
 	}
 
 	// Hash the new password
 	hashedPassword, err := HashPassword(input.NewPassword)
 	if err != nil {
-		return false, fmt.Errorf("failed to hash password: %v", err)
+		return false, fmt.Errorf("failed to hash password: %w", err)
 	}
 
 	// Update the password in the database
-	if err := db.Model(&user).Update("password", hashedPassword).Error; err != nil {
-		return false, fmt.Errorf("failed to update password: %v", err)
+	before := *caller
+	caller.Password = hashedPassword
+	if err := store.SaveUser(caller); err != nil {
+		return false, fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "user.update_password", "user", caller.Username, before, caller); err != nil {
+		return false, err
 	}
 
 	return true, nil
@@ -421,7 +467,7 @@ func authUser(db *gorm.DB, args string) (bool, error) {
 		Password string `json:"password"`
 	}
 	if err := json.Unmarshal([]byte(args), &input); err != nil {
-		return false, fmt.Errorf("invalid input: %v", err)
+		return false, fmt.Errorf("invalid input: %w", err)
 	}
 
 	// Fetch the user from the database
@@ -430,7 +476,7 @@ func authUser(db *gorm.DB, args string) (bool, error) {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return false, nil // User not found, authentication fails
 		}
-		return false, fmt.Errorf("failed to fetch user: %v", err)
+		return false, fmt.Errorf("failed to fetch user: %w", err)
 	}
 
 	// Check if the user is currently locked out
@@ -443,7 +489,7 @@ func authUser(db *gorm.DB, args string) (bool, error) {
 		// Lockout period has expired; reset failed attempts
 		user.FailedAttempts = 0
 		if err := db.Save(&user).Error; err != nil {
-			return false, fmt.Errorf("failed to reset failed attempts: %v", err)
+			return false, fmt.Errorf("failed to reset failed attempts: %w", err)
 		}
 	}
 
@@ -454,7 +500,7 @@ func authUser(db *gorm.DB, args string) (bool, error) {
 		user.FailedAttempts++
 		user.LastFailedLogin = time.Now()
 		if err := db.Save(&user).Error; err != nil {
-			return false, fmt.Errorf("failed to update failed attempts: %v", err)
+			return false, fmt.Errorf("failed to update failed attempts: %w", err)
 		}
 
 		if user.FailedAttempts >= maxFailedAttempts {
@@ -467,7 +513,7 @@ func authUser(db *gorm.DB, args string) (bool, error) {
 	// Reset failed attempts on successful login
 	user.FailedAttempts = 0
 	if err := db.Save(&user).Error; err != nil {
-		return false, fmt.Errorf("failed to reset failed attempts: %v", err)
+		return false, fmt.Errorf("failed to reset failed attempts: %w", err)
 	}
 
 	return true, nil
@@ -489,7 +535,7 @@ func sendPasswordResetEmail(db *gorm.DB, email string) error {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return fmt.Errorf("no user found with the given email")
 		}
-		return fmt.Errorf("failed to fetch user: %v", err)
+		return fmt.Errorf("failed to fetch user: %w", err)
 	}
 
 	// Generate a secure reset token
@@ -503,13 +549,13 @@ func sendPasswordResetEmail(db *gorm.DB, email string) error {
 		Expires: resetExpiry,
 	}
 	if err := db.Create(&passwordReset).Error; err != nil {
-		return fmt.Errorf("failed to save password reset token: %v", err)
+		return fmt.Errorf("failed to save password reset token: %w", err)
 	}
 
 	// Send the email (use an email service)
 	emailBody := fmt.Sprintf("Click the link to reset your password: https://example.com/reset?token=%s", resetToken)
 	if err := sendEmail(user.Email, "Password Reset Request", emailBody); err != nil {
-		return fmt.Errorf("failed to send password reset email: %v", err)
+		return fmt.Errorf("failed to send password reset email: %w", err)
 	}
 
 	return nil
@@ -2,10 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"os"
+	"slices"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -76,15 +80,25 @@ func main() {
 }
 */
 
-// Global Database Connection
-var db *gorm.DB
-
-func genericHandler[T any](db *gorm.DB, fn interface{}) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+// Global store, selected at startup
+var store Store
+
+// withCORS sets the CORS headers for requests from an allowed origin and
+// answers preflight requests. "*" in origins allows any origin.
+func withCORS(origins []string, next http.Handler) http.Handler {
+	allowAny := slices.Contains(origins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		switch {
+		case allowAny:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && slices.Contains(origins, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed")
 
 		// Handle OPTIONS request for preflight
 		if r.Method == http.MethodOptions {
@@ -92,88 +106,102 @@ func genericHandler[T any](db *gorm.DB, fn interface{}) http.HandlerFunc {
 			return
 		}
 
-		var input string
-
-		// Parse request body for non-GET methods
-		if r.Method != http.MethodGet && r.Body != nil {
-			defer r.Body.Close()
-			bodyBytes, err := io.ReadAll(r.Body)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
-				return
-			}
-			input = string(bodyBytes)
-		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		// Dynamic function handling
-		switch typedFn := fn.(type) {
-		case func(*gorm.DB, string) (T, error):
-			// Function expects (T, error)
-			result, err := typedFn(db, input)
-			if err != nil {
-				http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(result)
-
-		case func(*gorm.DB) ([]T, error):
-			// Function expects ([]T, error)
-			result, err := typedFn(db)
-			if err != nil {
-				http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(result)
-
-		case func(*gorm.DB, string) error:
-			// Function expects only error
-			err := typedFn(db, input)
-			if err != nil {
-				http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{"message": "Success"})
+func main() {
+	// Resolve the configuration from defaults, config file, environment and flags
+	config, cmd, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cmd.PrintConfig {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(config.Redacted())
+		return
+	}
 
-		default:
-			http.Error(w, `{"error": "Invalid function signature"}`, http.StatusInternalServerError)
+	// Run a subcommand instead of the server if one was given
+	if len(cmd.Command) > 0 {
+		if cmd.Command[0] != "migrate" {
+			log.Fatalf("Unknown command %q", cmd.Command[0])
+		}
+		if err := runMigrateCommand(config, cmd.Command[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
 		}
+		return
 	}
-}
-
 
-func init() {
-	// Initialize the database connection
-	db = connectDatabase()
-    
-	// Perform auto-migration
-	migrateDatabase(db)
+	signingKey = loadSigningKey(config.SigningKey)
+	idempotencyWindow = config.idempotencyWindow()
+	numberLocation = config.location()
 
-	fmt.Println("Database connected successfully")
-}
+	store, err = openStore(config)
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
 
-func main() {
-	// Create HTTP routes for all functions
-	http.HandleFunc("/contract_type_ls", genericHandler[[]ContractType](db, listContractTypes))
-    http.HandleFunc("/contract_type_create", genericHandler[struct{}](db, createContractType))
-    http.HandleFunc("/contract_type_set_active", genericHandler[struct{}](db, setActiveContractType))
-    http.HandleFunc("/contract_ls", genericHandler[[]Contract](db, listContracts))
-    http.HandleFunc("/claim_ls", genericHandler[[]Claim](db, listClaims))
-    http.HandleFunc("/contract_create", genericHandler[*Contract](db, createContract))
-	http.HandleFunc("/claim_file", genericHandler[struct{}](db, fileClaim))
-	http.HandleFunc("/claim_process", genericHandler[struct{}](db, processClaim))
-	http.HandleFunc("/user_authenticate", genericHandler[bool](db, authUser))
-	http.HandleFunc("/user_get_info", genericHandler[map[string]string](db, getUser))
-	http.HandleFunc("/repair_order_ls", genericHandler[[]map[string]interface{}](db, listRepairOrders))
-	http.HandleFunc("/repair_order_complete", genericHandler[struct{}](db, completeRepairOrder))
-	http.HandleFunc("/theft_claim_ls", genericHandler[[]map[string]interface{}](db, listTheftClaims))
-	http.HandleFunc("/theft_claim_process", genericHandler[struct{}](db, processTheftClaim))
+	// Create the initial insurer account if configured
+	bootstrapInsurer(store, config.InsurerUsername, config.InsurerPassword)
+
+	// Drop stored responses whose idempotency window has passed
+	go purgeIdempotencyRecords(store, time.Hour)
+
+	// Resource routes, each followed by the legacy route it replaces
+	handleRoute(store, "GET /contract_types", "/contract_type_ls", listContractTypes)
+	handleRoute(store, "POST /contract_types", "/contract_type_create", createContractType)
+	handleRoute(store, "GET /contract_types/{uuid}", "", getContractType)
+	handleRoute(store, "PATCH /contract_types/{uuid}", "/contract_type_set_active", setActiveContractType)
+	handleRoute(store, "GET /contract_types/{uuid}/eligibility", "", checkEligibility)
+	handleRoute(store, "GET /contracts", "/contract_ls", listContracts)
+	handleRoute(store, "POST /contracts", "/contract_create", createContract)
+	handleRoute(store, "POST /quotes", "/contract_quote", quoteContract)
+	handleRoute(store, "GET /contracts/{contract_uuid}/provenance", "/contract_provenance", getContractProvenance)
+	handleRoute(store, "GET /contracts/{contract_uuid}/token", "", getToken)
+	handleRoute(store, "GET /claims", "/claim_ls", listClaims)
+	handleRoute(store, "POST /claims", "/claim_file", fileClaim)
+	handleRoute(store, "GET /claims/{uuid}", "", getClaim)
+	handleRoute(store, "PATCH /claims/{uuid}", "/claim_process", processClaim)
+	handleRoute(store, "GET /claims/{uuid}/actions", "/claim_actions", listClaimActions)
+	handleRoute(store, "GET /theft_claims", "/theft_claim_ls", listTheftClaims)
+	handleRoute(store, "GET /theft_claims/{uuid}", "", getTheftClaim)
+	handleRoute(store, "PATCH /theft_claims/{uuid}", "/theft_claim_process", processTheftClaim)
+	handleRoute(store, "GET /repair_orders", "/repair_order_ls", listRepairOrders)
+	handleRoute(store, "GET /repair_orders/{uuid}", "", getRepairOrder)
+	handleRoute(store, "PATCH /repair_orders/{uuid}", "/repair_order_complete", completeRepairOrder)
+	handlePublicRoute(store, "POST /sessions", "/user_authenticate", authUser)
+	handlePublicRoute(store, "POST /sessions/refresh", "/user_refresh", refreshSession)
+	handleRoute(store, "POST /users", "/user_create", createUser)
+	handleRoute(store, "GET /users/me", "/user_get_info", getUser)
+	handleRoute(store, "PUT /users/me/password", "/user_update_password", updatePassword)
+	handleRoute(store, "GET /transfers", "/contract_transfer_ls", listContractTransfers)
+	handleRoute(store, "POST /transfers", "/contract_transfer_initiate", initiateContractTransfer)
+	handleRoute(store, "GET /transfers/{uuid}", "", getContractTransfer)
+	handleRoute(store, "POST /transfers/{uuid}/accept", "/contract_transfer_accept", acceptContractTransfer)
+	handleRoute(store, "POST /transfers/{uuid}/cancel", "/contract_transfer_cancel", cancelContractTransfer)
+	handleRoute(store, "GET /tokens", "/token_ls", listTokens)
+	handleRoute(store, "GET /tokens/{token_id}", "/token_get", getToken)
+	handleRoute(store, "GET /tokens/{token_id}/verification", "/token_verify", verifyToken)
+	registerRoute("GET /token_metadata/{token_id}", "", tokenMetadataHandler(store))
+	documentRoute(routeDoc{Pattern: "GET /token_metadata/{token_id}", Public: true, Response: typeOf[TokenMetadata]()})
+	registerRoute("GET /token_image/{token_id}", "", tokenImageHandler(store))
+	documentRoute(routeDoc{Pattern: "GET /token_image/{token_id}", Public: true, ContentType: "image/svg+xml"})
+	handleRoute(store, "GET /audit_entries", "/audit_ls", listAuditEntries)
+	handleRoute(store, "GET /audit_entries/verification", "/audit_verify", verifyAuditChain)
+	verifyRoutes()
+
+	// API documentation, generated from the routes above
+	registerRoute("GET /openapi.json", "", openAPIHandler())
+	registerRoute("GET /docs", "", docsHandler)
 
 	// Start the server
-	fmt.Println("Starting server on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	fmt.Printf("Starting server on port %d...\n", config.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", config.Port), withCORS(config.CORSOrigins, http.DefaultServeMux)))
 
 	/*http.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -190,27 +218,14 @@ func main() {
 	
 }
 
-func connectDatabase() *gorm.DB {
-
-    dsn := "host=localhost user=postgres password=yourpassword dbname=contract_management port=5432 sslmode=disable TimeZone=Asia/Shanghai"
-    var err error
-    db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-    if err != nil {
-        log.Fatalf("Failed to connect to database: %v", err)
-    }
-	
-	// return db
-	fmt.Println("Mock database connection initialized")
-	return &gorm.DB{}
-}
-
-
-func migrateDatabase(db *gorm.DB) {
-	err := db.AutoMigrate(&User{}, &ContractType{}, &Contract{}, &Claim{}, &RepairOrder{})
+func connectDatabase(dsn string) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
-	log.Println("Database migrated successfully")
+	fmt.Println("Database connected successfully")
+	return db, nil
 }
 
+
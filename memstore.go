@@ -0,0 +1,703 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore implements Store in process memory. Records are copied on the
+// way in and out, so callers never share state with the store.
+type memoryStore struct {
+	mu sync.RWMutex
+	memoryTables
+}
+
+// memoryTables holds the records of a memoryStore.
+type memoryTables struct {
+	users         map[string]User
+	contractTypes map[string]ContractType
+	contracts     map[string]Contract
+	claims        map[string]Claim
+	repairOrders  map[string]RepairOrder
+	auditEntries  []AuditEntry
+	tokens        map[string]ContractToken
+	transfers     map[string]ContractTransfer
+	idempotency   map[string]IdempotencyRecord
+	numbers       map[string]int64
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{memoryTables: memoryTables{
+		users:         map[string]User{},
+		contractTypes: map[string]ContractType{},
+		contracts:     map[string]Contract{},
+		claims:        map[string]Claim{},
+		repairOrders:  map[string]RepairOrder{},
+		tokens:        map[string]ContractToken{},
+		transfers:     map[string]ContractTransfer{},
+		idempotency:   map[string]IdempotencyRecord{},
+		numbers:       map[string]int64{},
+	}}
+}
+
+// clone copies the tables. Records are never modified in place, so they can
+// be shared between the copies.
+func (t memoryTables) clone() memoryTables {
+	return memoryTables{
+		users:         maps.Clone(t.users),
+		contractTypes: maps.Clone(t.contractTypes),
+		contracts:     maps.Clone(t.contracts),
+		claims:        maps.Clone(t.claims),
+		repairOrders:  maps.Clone(t.repairOrders),
+		auditEntries:  slices.Clone(t.auditEntries),
+		tokens:        maps.Clone(t.tokens),
+		transfers:     maps.Clone(t.transfers),
+		idempotency:   maps.Clone(t.idempotency),
+		numbers:       maps.Clone(t.numbers),
+	}
+}
+
+// sortedValues returns the map's values ordered by key, keeping listings
+// deterministic.
+func sortedValues[T any](m map[string]T, keep func(T) bool) []T {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := []T{}
+	for _, key := range keys {
+		if keep(m[key]) {
+			values = append(values, m[key])
+		}
+	}
+	return values
+}
+
+// page orders the items matching a filter and cuts out the page opts asks
+// for, counting them first if opts.Total is set.
+func page[T any](items []T, spec listSpec[T], opts ListOptions) []T {
+	column := opts.Sort
+	if column == "" {
+		column = spec.DefaultSort
+	}
+	value, key := spec.Columns[column], spec.Columns[spec.Key]
+
+	// position compares an item with the (value, key) position given
+	position := func(item *T, v, k interface{}) int {
+		if c := compareValues(value(item), v); c != 0 {
+			return c
+		}
+		return compareValues(key(item), k)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		c := position(&items[i], value(&items[j]), key(&items[j]))
+		if opts.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if opts.Total != nil {
+		*opts.Total = int64(len(items))
+	}
+	if opts.After != nil {
+		start := sort.Search(len(items), func(i int) bool {
+			c := position(&items[i], opts.After.Value, opts.After.Key)
+			if opts.Descending {
+				return c < 0
+			}
+			return c > 0
+		})
+		items = items[start:]
+	}
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+	}
+	return items
+}
+
+func duplicateKey(kind, key string) error {
+	return fmt.Errorf("%w: %s %q", ErrDuplicate, kind, key)
+}
+
+func missingReference(kind, key string) error {
+	return fmt.Errorf("%w: %s %q", ErrMissingReference, kind, key)
+}
+
+// The tables hold records without their associations, like the rows of the
+// GORM store. userRow and claimRow strip them; cloneContract does for
+// contracts.
+
+func userRow(u User) User {
+	u.Contracts = nil
+	return u
+}
+
+func claimRow(c Claim) Claim {
+	c.RepairOrder = nil
+	return c
+}
+
+func cloneContractType(ct ContractType) ContractType {
+	if ct.Transferable != nil {
+		transferable := *ct.Transferable
+		ct.Transferable = &transferable
+	}
+	return ct
+}
+
+func cloneContract(c Contract) Contract {
+	c.Claims = nil
+	return c
+}
+
+func cloneTransfer(t ContractTransfer) ContractTransfer {
+	if t.ResolvedAt != nil {
+		resolvedAt := *t.ResolvedAt
+		t.ResolvedAt = &resolvedAt
+	}
+	return t
+}
+
+// Transaction runs fn against a copy of the store and keeps the copy only if
+// fn succeeds. The store stays write-locked meanwhile, so transactions run
+// one at a time and every record is effectively locked for update.
+func (s *memoryStore) Transaction(fn func(tx Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryStore{memoryTables: s.memoryTables.clone()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.memoryTables = tx.memoryTables
+	return nil
+}
+
+// Users
+
+func (s *memoryStore) GetUser(username string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *memoryStore) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.Username]; ok {
+		return duplicateKey("user", user.Username)
+	}
+	if user.Role == "" {
+		user.Role = RoleCustomer
+	}
+	s.users[user.Username] = userRow(*user)
+	return nil
+}
+
+func (s *memoryStore) SaveUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.Username] = userRow(*user)
+	return nil
+}
+
+// Contract types
+
+func (s *memoryStore) GetContractType(uuid string) (*ContractType, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contractType, ok := s.contractTypes[uuid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	contractType = cloneContractType(contractType)
+	return &contractType, nil
+}
+
+func (s *memoryStore) ListContractTypes(filter ContractTypeFilter) ([]ContractType, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shopType := strings.ToLower(filter.ShopType)
+	contractTypes := page(sortedValues(s.contractTypes, func(ct ContractType) bool {
+		return (filter.Active == nil || ct.Active == *filter.Active) &&
+			strings.Contains(strings.ToLower(ct.ShopType), shopType)
+	}), contractTypeList, filter.ListOptions)
+	for i := range contractTypes {
+		contractTypes[i] = cloneContractType(contractTypes[i])
+	}
+	return contractTypes, nil
+}
+
+func (s *memoryStore) CreateContractType(contractType *ContractType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.contractTypes[contractType.UUID]; ok {
+		return duplicateKey("contract type", contractType.UUID)
+	}
+	contractType.Version = 1
+	s.contractTypes[contractType.UUID] = cloneContractType(*contractType)
+	return nil
+}
+
+func (s *memoryStore) SaveContractType(contractType *ContractType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.contractTypes[contractType.UUID]; ok && stored.Version != contractType.Version {
+		return ErrVersionConflict
+	}
+	contractType.Version++
+	s.contractTypes[contractType.UUID] = cloneContractType(*contractType)
+	return nil
+}
+
+// Contracts
+
+func (s *memoryStore) GetContract(uuid string) (*Contract, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contract, ok := s.contracts[uuid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	contract = cloneContract(contract)
+	return &contract, nil
+}
+
+// GetContractForUpdate needs no row lock, transactions holding the whole
+// store.
+func (s *memoryStore) GetContractForUpdate(uuid string) (*Contract, error) {
+	return s.GetContract(uuid)
+}
+
+func (s *memoryStore) ListContracts(filter ContractFilter) ([]Contract, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contracts := page(sortedValues(s.contracts, func(c Contract) bool {
+		return (filter.Number == "" || c.Number == filter.Number) &&
+			(filter.Username == "" || c.Username == filter.Username) &&
+			(filter.ContractTypeUUID == "" || c.ContractTypeUUID == filter.ContractTypeUUID) &&
+			(filter.Void == nil || c.Void == *filter.Void) &&
+			inRange(c.StartDate, filter.StartFrom, filter.StartTo)
+	}), contractList, filter.ListOptions)
+	for i := range contracts {
+		contracts[i] = cloneContract(contracts[i])
+		contracts[i].Claims = sortedValues(s.claims, func(c Claim) bool {
+			return c.ContractUUID == contracts[i].UUID
+		})
+	}
+	return contracts, nil
+}
+
+func (s *memoryStore) CreateContract(contract *Contract) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.contracts[contract.UUID]; ok {
+		return duplicateKey("contract", contract.UUID)
+	}
+	if _, ok := s.users[contract.Username]; !ok {
+		return missingReference("user", contract.Username)
+	}
+	contract.Version = 1
+	s.contracts[contract.UUID] = cloneContract(*contract)
+	return nil
+}
+
+func (s *memoryStore) SaveContract(contract *Contract) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.contracts[contract.UUID]; ok && stored.Version != contract.Version {
+		return ErrVersionConflict
+	}
+	contract.Version++
+	s.contracts[contract.UUID] = cloneContract(*contract)
+	return nil
+}
+
+// Claims
+
+func (s *memoryStore) GetClaim(uuid string) (*Claim, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	claim, ok := s.claims[uuid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &claim, nil
+}
+
+func (s *memoryStore) GetClaimForUpdate(uuid string) (*Claim, error) {
+	return s.GetClaim(uuid)
+}
+
+func (s *memoryStore) ListClaims(filter ClaimFilter) ([]Claim, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return page(sortedValues(s.claims, func(c Claim) bool {
+		return (filter.Number == "" || c.Number == filter.Number) &&
+			(filter.ContractUUID == "" || c.ContractUUID == filter.ContractUUID) &&
+			(filter.Status == ClaimStatusUnknown || c.Status == filter.Status) &&
+			(filter.IsTheft == nil || c.IsTheft == *filter.IsTheft) &&
+			inRange(c.Date, filter.DateFrom, filter.DateTo)
+	}), claimList, filter.ListOptions), nil
+}
+
+func (s *memoryStore) CreateClaim(claim *Claim) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.claims[claim.UUID]; ok {
+		return duplicateKey("claim", claim.UUID)
+	}
+	if _, ok := s.contracts[claim.ContractUUID]; !ok {
+		return missingReference("contract", claim.ContractUUID)
+	}
+	claim.Version = 1
+	s.claims[claim.UUID] = claimRow(*claim)
+	return nil
+}
+
+func (s *memoryStore) SaveClaim(claim *Claim) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.claims[claim.UUID]; ok && stored.Version != claim.Version {
+		return ErrVersionConflict
+	}
+	claim.Version++
+	s.claims[claim.UUID] = claimRow(*claim)
+	return nil
+}
+
+// Repair orders
+
+func (s *memoryStore) GetRepairOrder(uuid string) (*RepairOrder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	repairOrder, ok := s.repairOrders[uuid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &repairOrder, nil
+}
+
+func (s *memoryStore) GetRepairOrderByClaim(claimUUID string) (*RepairOrder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, repairOrder := range s.repairOrders {
+		if repairOrder.ClaimUUID == claimUUID {
+			return &repairOrder, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) GetRepairOrderByClaimForUpdate(claimUUID string) (*RepairOrder, error) {
+	return s.GetRepairOrderByClaim(claimUUID)
+}
+
+func (s *memoryStore) ListRepairOrders(filter RepairOrderFilter) ([]RepairOrder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return page(sortedValues(s.repairOrders, func(r RepairOrder) bool {
+		return (filter.Ready == nil || r.Ready == *filter.Ready) &&
+			(filter.ContractUUID == "" || r.ContractUUID == filter.ContractUUID)
+	}), repairOrderList, filter.ListOptions), nil
+}
+
+func (s *memoryStore) CreateRepairOrder(repairOrder *RepairOrder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.repairOrders[repairOrder.UUID]; ok {
+		return duplicateKey("repair order", repairOrder.UUID)
+	}
+	if _, ok := s.claims[repairOrder.ClaimUUID]; !ok {
+		return missingReference("claim", repairOrder.ClaimUUID)
+	}
+	for _, other := range s.repairOrders {
+		if other.ClaimUUID == repairOrder.ClaimUUID {
+			return duplicateKey("repair order for claim", repairOrder.ClaimUUID)
+		}
+	}
+	repairOrder.Version = 1
+	s.repairOrders[repairOrder.UUID] = *repairOrder
+	return nil
+}
+
+func (s *memoryStore) SaveRepairOrder(repairOrder *RepairOrder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.repairOrders[repairOrder.UUID]; ok && stored.Version != repairOrder.Version {
+		return ErrVersionConflict
+	}
+	repairOrder.Version++
+	s.repairOrders[repairOrder.UUID] = *repairOrder
+	return nil
+}
+
+// Audit log
+
+func (s *memoryStore) LastAuditEntry() (*AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.auditEntries) == 0 {
+		return nil, ErrNotFound
+	}
+	entry := s.auditEntries[len(s.auditEntries)-1]
+	return &entry, nil
+}
+
+func (s *memoryStore) AppendAuditEntry(entry *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = uint64(len(s.auditEntries) + 1)
+	s.auditEntries = append(s.auditEntries, *entry)
+	return nil
+}
+
+func (s *memoryStore) ListAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []AuditEntry{}
+	for _, entry := range s.auditEntries {
+		if (filter.EntityType == "" || entry.EntityType == filter.EntityType) &&
+			(filter.EntityID == "" || entry.EntityID == filter.EntityID) {
+			entries = append(entries, entry)
+		}
+	}
+	return page(entries, auditEntryList, filter.ListOptions), nil
+}
+
+func (s *memoryStore) WalkAuditEntries(fn func(entry *AuditEntry) error) error {
+	s.mu.RLock()
+	entries := append([]AuditEntry(nil), s.auditEntries...)
+	s.mu.RUnlock()
+
+	for i := range entries {
+		if err := fn(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contract tokens
+
+func (s *memoryStore) GetToken(tokenID string) (*ContractToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[tokenID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+func (s *memoryStore) GetTokenByContract(contractUUID string) (*ContractToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.tokens {
+		if token.ContractUUID == contractUUID {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) ListTokens(filter TokenFilter) ([]ContractToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return page(sortedValues(s.tokens, func(t ContractToken) bool {
+		return t.Owner == filter.Owner
+	}), tokenList, filter.ListOptions), nil
+}
+
+func (s *memoryStore) CreateToken(token *ContractToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.TokenID]; ok {
+		return duplicateKey("token", token.TokenID)
+	}
+	for _, existing := range s.tokens {
+		if existing.ContractUUID == token.ContractUUID {
+			return duplicateKey("token for contract", token.ContractUUID)
+		}
+	}
+	s.tokens[token.TokenID] = *token
+	return nil
+}
+
+func (s *memoryStore) SaveToken(token *ContractToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.TokenID] = *token
+	return nil
+}
+
+// Contract transfers
+
+func (s *memoryStore) GetTransfer(uuid string) (*ContractTransfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transfer, ok := s.transfers[uuid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	transfer = cloneTransfer(transfer)
+	return &transfer, nil
+}
+
+func (s *memoryStore) GetTransferForUpdate(uuid string) (*ContractTransfer, error) {
+	return s.GetTransfer(uuid)
+}
+
+func (s *memoryStore) ListTransfers(filter TransferFilter) ([]ContractTransfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transfers := sortedValues(s.transfers, func(t ContractTransfer) bool {
+		return (filter.ContractUUID == "" || t.ContractUUID == filter.ContractUUID) &&
+			(filter.Username == "" || t.FromUsername == filter.Username || t.ToUsername == filter.Username) &&
+			(filter.Status == "" || t.Status == filter.Status)
+	})
+	transfers = page(transfers, transferList, filter.ListOptions)
+	for i := range transfers {
+		transfers[i] = cloneTransfer(transfers[i])
+	}
+	return transfers, nil
+}
+
+func (s *memoryStore) CreateTransfer(transfer *ContractTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.transfers[transfer.UUID]; ok {
+		return duplicateKey("transfer", transfer.UUID)
+	}
+	transfer.Version = 1
+	s.transfers[transfer.UUID] = cloneTransfer(*transfer)
+	return nil
+}
+
+func (s *memoryStore) SaveTransfer(transfer *ContractTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.transfers[transfer.UUID]; ok && stored.Version != transfer.Version {
+		return ErrVersionConflict
+	}
+	transfer.Version++
+	s.transfers[transfer.UUID] = cloneTransfer(*transfer)
+	return nil
+}
+
+// Idempotency keys
+
+// idempotencyKey is the key of an idempotency record in its table.
+func idempotencyKey(username, key string) string {
+	return username + "\x00" + key
+}
+
+func cloneIdempotencyRecord(r IdempotencyRecord) IdempotencyRecord {
+	r.Body = bytes.Clone(r.Body)
+	return r
+}
+
+func (s *memoryStore) GetIdempotencyRecord(username, key string) (*IdempotencyRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.idempotency[idempotencyKey(username, key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	record = cloneIdempotencyRecord(record)
+	return &record, nil
+}
+
+func (s *memoryStore) CreateIdempotencyRecord(record *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.idempotency[idempotencyKey(record.Username, record.Key)]; ok {
+		return duplicateKey("idempotency key", record.Key)
+	}
+	s.idempotency[idempotencyKey(record.Username, record.Key)] = cloneIdempotencyRecord(*record)
+	return nil
+}
+
+func (s *memoryStore) SaveIdempotencyRecord(record *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idempotency[idempotencyKey(record.Username, record.Key)] = cloneIdempotencyRecord(*record)
+	return nil
+}
+
+func (s *memoryStore) DeleteIdempotencyRecord(username, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idempotency, idempotencyKey(username, key))
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredIdempotencyRecords(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maps.DeleteFunc(s.idempotency, func(_ string, r IdempotencyRecord) bool {
+		return !now.Before(r.ExpiresAt)
+	})
+	return nil
+}
+
+// Record numbers
+
+func (s *memoryStore) NextNumber(prefix string, year int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := fmt.Sprintf("%s-%d", prefix, year)
+	s.numbers[key]++
+	return s.numbers[key], nil
+}
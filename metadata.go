@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// TokenMetadata is the ERC-721 metadata JSON document of a contract token,
+// as returned by a tokenURI.
+type TokenMetadata struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Image       string           `json:"image"`
+	ImageData   string           `json:"image_data"`
+	ExternalURL string           `json:"external_url,omitempty"`
+	Attributes  []TokenAttribute `json:"attributes"`
+}
+
+// TokenAttribute is a trait in the OpenSea attribute format.
+type TokenAttribute struct {
+	TraitType   string      `json:"trait_type"`
+	Value       interface{} `json:"value"`
+	DisplayType string      `json:"display_type,omitempty"`
+}
+
+// tokenDetails gathers everything needed to describe a token.
+type tokenDetails struct {
+	Token        ContractToken
+	Contract     Contract
+	ContractType ContractType
+}
+
+func loadTokenDetails(store Store, tokenID string) (*tokenDetails, error) {
+	token, err := store.GetToken(tokenID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("token_not_found", "token not found")
+		}
+		return nil, fmt.Errorf("failed to fetch token: %w", err)
+	}
+	contract, err := store.GetContract(token.ContractUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract: %w", err)
+	}
+	contractType, err := store.GetContractType(contract.ContractTypeUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract type: %w", err)
+	}
+	return &tokenDetails{Token: *token, Contract: *contract, ContractType: *contractType}, nil
+}
+
+func buildTokenMetadata(details *tokenDetails, imageURL string) *TokenMetadata {
+	contract, contractType := &details.Contract, &details.ContractType
+
+	status := "Active"
+	if contract.Void {
+		status = "Void"
+	}
+	theftCoverage := "No"
+	if contractType.TheftInsured {
+		theftCoverage = "Yes"
+	}
+
+	return &TokenMetadata{
+		Name:        fmt.Sprintf("%s %s Insurance Policy", contract.Item.Brand, contract.Item.Model),
+		Description: fmt.Sprintf("%s covering %s %s (serial %s) from %s to %s.", contractType.Description, contract.Item.Brand, contract.Item.Model, contract.Item.SerialNo, contract.StartDate.UTC().Format("2006-01-02"), contract.EndDate.UTC().Format("2006-01-02")),
+		Image:       imageURL,
+		ImageData:   renderTokenSVG(details),
+		Attributes: []TokenAttribute{
+			{TraitType: "Brand", Value: contract.Item.Brand},
+			{TraitType: "Model", Value: contract.Item.Model},
+			{TraitType: "Serial Number", Value: contract.Item.SerialNo},
+			{TraitType: "Coverage", Value: contractType.Description},
+			{TraitType: "Theft Coverage", Value: theftCoverage},
+			{TraitType: "Max Sum Insured", Value: contractType.MaxSumInsured, DisplayType: "number"},
+			{TraitType: "Start Date", Value: contract.StartDate.Unix(), DisplayType: "date"},
+			{TraitType: "End Date", Value: contract.EndDate.Unix(), DisplayType: "date"},
+			{TraitType: "Status", Value: status},
+		},
+	}
+}
+
+// renderTokenSVG draws the policy card for a token. The output depends only
+// on the token and contract, so the same token always renders the same image.
+func renderTokenSVG(details *tokenDetails) string {
+	contract, contractType := &details.Contract, &details.ContractType
+
+	// Derive the card colours from the token ID
+	hue := 0
+	for _, c := range details.Token.TokenID[:4] {
+		hue = hue*16 + strings.IndexRune("0123456789abcdef", c)
+	}
+	hue %= 360
+
+	theftCoverage := "Theft not covered"
+	if contractType.TheftInsured {
+		theftCoverage = "Theft covered"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="250" viewBox="0 0 400 250">`)
+	fmt.Fprintf(&sb, `<defs><linearGradient id="bg" x1="0" y1="0" x2="1" y2="1"><stop offset="0" stop-color="hsl(%d,60%%,35%%)"/><stop offset="1" stop-color="hsl(%d,60%%,20%%)"/></linearGradient></defs>`, hue, (hue+40)%360)
+	fmt.Fprintf(&sb, `<rect width="400" height="250" rx="16" fill="url(#bg)"/>`)
+	fmt.Fprintf(&sb, `<g font-family="Helvetica, Arial, sans-serif" fill="#ffffff">`)
+	fmt.Fprintf(&sb, `<text x="24" y="44" font-size="22" font-weight="bold">%s %s</text>`, svgText(contract.Item.Brand), svgText(contract.Item.Model))
+	fmt.Fprintf(&sb, `<text x="24" y="70" font-size="13" opacity="0.8">Serial %s</text>`, svgText(contract.Item.SerialNo))
+	fmt.Fprintf(&sb, `<text x="24" y="110" font-size="14">%s</text>`, svgText(contractType.Description))
+	fmt.Fprintf(&sb, `<text x="24" y="132" font-size="13" opacity="0.8">%s · up to %.2f</text>`, theftCoverage, contractType.MaxSumInsured)
+	fmt.Fprintf(&sb, `<text x="24" y="172" font-size="13">%s – %s</text>`, contract.StartDate.UTC().Format("2006-01-02"), contract.EndDate.UTC().Format("2006-01-02"))
+	fmt.Fprintf(&sb, `<text x="24" y="226" font-size="9" font-family="monospace" opacity="0.6">#%s</text>`, details.Token.TokenID[:32])
+	if contract.Void {
+		fmt.Fprintf(&sb, `<text x="376" y="44" font-size="20" font-weight="bold" text-anchor="end" fill="#ff6b6b">VOID</text>`)
+	}
+	fmt.Fprintf(&sb, `</g></svg>`)
+
+	return sb.String()
+}
+
+func svgText(s string) string {
+	return html.EscapeString(s)
+}
+
+// requestBaseURL reconstructs the externally visible base URL of a request.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// tokenMetadataHandler serves GET /token_metadata/{token_id}, the tokenURI of
+// a contract token. It is public so that wallets and marketplaces can read it.
+func tokenMetadataHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenID := r.PathValue("token_id")
+		details, err := loadTokenDetails(store, tokenID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		metadata := buildTokenMetadata(details, requestBaseURL(r)+"/token_image/"+tokenID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metadata)
+	}
+}
+
+// tokenImageHandler serves GET /token_image/{token_id} as an SVG card.
+func tokenImageHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenID := strings.TrimSuffix(r.PathValue("token_id"), ".svg")
+		details, err := loadTokenDetails(store, tokenID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(renderTokenSVG(details)))
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles holds the numbered SQL migrations. Each version has an
+// up and a down file named NNNN_<name>.up.sql and NNNN_<name>.down.sql.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsDir is where `migrate create` writes new migrations, relative to
+// the source tree.
+const migrationsDir = "migrations"
+
+// migrationLockID is the Postgres advisory lock taken while migrating, so
+// that concurrent instances do not apply the same migration twice.
+const migrationLockID = 7342001
+
+var migrationFilePattern = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var migrationNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration records an applied migration in schema_migrations.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// loadMigrations reads and orders the migrations in fsys.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, migrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected file in migrations: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := fs.ReadFile(fsys, migrationsDir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// appliedMigrations returns the applied migrations by version, creating
+// schema_migrations on first use.
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL
+	)`).Error; err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// pendingMigrations returns the migrations not yet applied, in order.
+func pendingMigrations(db *gorm.DB, migrations []migration) ([]migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// applyMigration runs one migration in its own transaction, together with
+// the schema_migrations bookkeeping, so a failing migration leaves no trace.
+func applyMigration(db *gorm.DB, m migration, up bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+			return fmt.Errorf("failed to lock schema_migrations: %v", err)
+		}
+
+		// Another instance may have got here first
+		var count int64
+		if err := tx.Model(&SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to read schema_migrations: %v", err)
+		}
+		if (count > 0) == up {
+			return nil
+		}
+
+		if up {
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %v", m.Version, m.Name, err)
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		}
+
+		if err := tx.Exec(m.Down).Error; err != nil {
+			return fmt.Errorf("rollback of %04d_%s failed: %v", m.Version, m.Name, err)
+		}
+		return tx.Delete(&SchemaMigration{}, "version = ?", m.Version).Error
+	})
+}
+
+// migrateUp applies every pending migration and returns how many ran.
+func migrateUp(db *gorm.DB, migrations []migration) (int, error) {
+	pending, err := pendingMigrations(db, migrations)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range pending {
+		if err := applyMigration(db, m, true); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// migrateDown rolls back the most recent steps migrations.
+func migrateDown(db *gorm.DB, migrations []migration, steps int) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	rolledBack := 0
+	for i := len(migrations) - 1; i >= 0 && rolledBack < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := applyMigration(db, m, false); err != nil {
+			return rolledBack, err
+		}
+		rolledBack++
+	}
+	return rolledBack, nil
+}
+
+// printMigrationStatus lists every known migration with when it was
+// applied. Applied versions without a file are reported as well.
+func printMigrationStatus(w io.Writer, db *gorm.DB, migrations []migration) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+	for _, m := range migrations {
+		status := "pending"
+		if row, ok := applied[m.Version]; ok {
+			status = row.AppliedAt.UTC().Format(time.RFC3339)
+			delete(applied, m.Version)
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", m.Version, m.Name, status)
+	}
+	for _, row := range applied {
+		fmt.Fprintf(tw, "%04d\t%s\t%s (no migration file)\n", row.Version, row.Name, row.AppliedAt.UTC().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// createMigration writes empty up and down files for the next version into
+// the migrations directory under root.
+func createMigration(root, name string) ([]string, error) {
+	if !migrationNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid migration name %q, use lowercase letters, digits and underscores", name)
+	}
+
+	migrations, err := loadMigrations(os.DirFS(root))
+	if err != nil {
+		return nil, err
+	}
+	version := 1
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].Version + 1
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(root, migrationsDir, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+		content := fmt.Sprintf("-- %04d_%s (%s)\n", version, name, direction)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write migration: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// runMigrateCommand implements `nft migrate up|down [steps]|status|create <name>`.
+func runMigrateCommand(config *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status|create <name>")
+	}
+
+	// create only touches the source tree
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errors.New("usage: migrate create <name>")
+		}
+		paths, err := createMigration(".", args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println("Created", strings.Join(paths, " and "))
+		return nil
+	}
+
+	if config.Store != "postgres" {
+		return fmt.Errorf("migrations apply to the postgres store, not %s", config.Store)
+	}
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+	db, err := connectDatabase(config.postgresDSN())
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrateUp(db, migrations)
+		fmt.Printf("Applied %d migration(s)\n", applied)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		rolledBack, err := migrateDown(db, migrations, steps)
+		fmt.Printf("Rolled back %d migration(s)\n", rolledBack)
+		return err
+	case "status":
+		return printMigrationStatus(os.Stdout, db, migrations)
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// prepareDatabase makes sure the schema is current before the server
+// starts. Pending migrations are applied if auto_migrate is set, otherwise
+// the server refuses to start.
+func prepareDatabase(db *gorm.DB, autoMigrate bool) error {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	if autoMigrate {
+		applied, err := migrateUp(db, migrations)
+		if err != nil {
+			return err
+		}
+		if applied > 0 {
+			fmt.Printf("Applied %d migration(s)\n", applied)
+		}
+		return nil
+	}
+
+	pending, err := pendingMigrations(db, migrations)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is %d migration(s) behind, run `migrate up` or set auto_migrate", len(pending))
+	}
+	return nil
+}
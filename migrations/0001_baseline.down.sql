@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS repair_orders;
+DROP TABLE IF EXISTS claims;
+DROP TABLE IF EXISTS contracts;
+DROP TABLE IF EXISTS contract_types;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,86 @@
+-- Baseline schema for the models previously created by AutoMigrate. Tables
+-- are created only if missing so that databases set up by AutoMigrate can
+-- adopt the migrations. Columns added to the models after those databases
+-- were created are added at the end of this file if they are missing.
+
+CREATE TABLE IF NOT EXISTS users (
+    username   text PRIMARY KEY,
+    password   text,
+    first_name text,
+    last_name  text,
+    role       text DEFAULT 'customer'
+);
+
+CREATE TABLE IF NOT EXISTS contract_types (
+    uuid              text PRIMARY KEY,
+    shop_type         text,
+    formula_per_day   text,
+    max_sum_insured   numeric,
+    theft_insured     boolean,
+    description       text,
+    conditions        text,
+    active            boolean,
+    min_duration_days integer,
+    max_duration_days integer,
+    transferable      boolean
+);
+
+CREATE TABLE IF NOT EXISTS contracts (
+    uuid               text PRIMARY KEY,
+    username           text,
+    id                 integer,
+    brand              text,
+    model              text,
+    price              numeric,
+    description        text,
+    serial_no          text,
+    start_date         timestamptz,
+    end_date           timestamptz,
+    premium            numeric,
+    void               boolean,
+    contract_type_uuid text
+);
+
+CREATE INDEX IF NOT EXISTS idx_contracts_username ON contracts (username);
+
+CREATE TABLE IF NOT EXISTS claims (
+    uuid           text PRIMARY KEY,
+    contract_uuid  text,
+    date           timestamptz,
+    description    text,
+    is_theft       boolean,
+    status         smallint,
+    reimbursable   numeric,
+    repaired       boolean,
+    file_reference text
+);
+
+CREATE INDEX IF NOT EXISTS idx_claims_contract_uuid ON claims (contract_uuid);
+
+CREATE TABLE IF NOT EXISTS repair_orders (
+    claim_uuid    text,
+    contract_uuid text,
+    id            integer,
+    brand         text,
+    model         text,
+    price         numeric,
+    description   text,
+    serial_no     text,
+    ready         boolean
+);
+
+CREATE INDEX IF NOT EXISTS idx_repair_orders_claim_uuid ON repair_orders (claim_uuid);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text DEFAULT 'customer';
+
+ALTER TABLE contract_types ADD COLUMN IF NOT EXISTS transferable boolean;
+
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS premium numeric;
+
+ALTER TABLE repair_orders
+    ADD COLUMN IF NOT EXISTS id          integer,
+    ADD COLUMN IF NOT EXISTS brand       text,
+    ADD COLUMN IF NOT EXISTS model       text,
+    ADD COLUMN IF NOT EXISTS price       numeric,
+    ADD COLUMN IF NOT EXISTS description text,
+    ADD COLUMN IF NOT EXISTS serial_no   text;
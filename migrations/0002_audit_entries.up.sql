@@ -0,0 +1,25 @@
+CREATE TABLE audit_entries (
+    id          bigserial PRIMARY KEY,
+    timestamp   timestamptz NOT NULL,
+    actor       text NOT NULL,
+    operation   text NOT NULL,
+    entity_type text NOT NULL,
+    entity_id   text NOT NULL,
+    before      text,
+    after       text,
+    prev_hash   text NOT NULL,
+    hash        text NOT NULL
+);
+
+CREATE INDEX idx_audit_entries_entity ON audit_entries (entity_type, entity_id);
+
+-- The audit log is append-only
+CREATE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_entries_append_only
+    BEFORE UPDATE OR DELETE ON audit_entries
+    FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only();
@@ -0,0 +1,10 @@
+CREATE TABLE contract_tokens (
+    token_id      text PRIMARY KEY,
+    contract_uuid text NOT NULL,
+    owner         text NOT NULL,
+    minted_at     timestamptz NOT NULL,
+    content_hash  text NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_contract_tokens_contract_uuid ON contract_tokens (contract_uuid);
+CREATE INDEX idx_contract_tokens_owner ON contract_tokens (owner);
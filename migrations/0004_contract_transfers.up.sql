@@ -0,0 +1,13 @@
+CREATE TABLE contract_transfers (
+    uuid          text PRIMARY KEY,
+    contract_uuid text NOT NULL,
+    from_username text NOT NULL,
+    to_username   text NOT NULL,
+    status        text NOT NULL,
+    initiated_at  timestamptz NOT NULL,
+    resolved_at   timestamptz
+);
+
+CREATE INDEX idx_contract_transfers_contract_uuid ON contract_transfers (contract_uuid);
+CREATE INDEX idx_contract_transfers_from_username ON contract_transfers (from_username);
+CREATE INDEX idx_contract_transfers_to_username ON contract_transfers (to_username);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN shop_type text;
@@ -0,0 +1 @@
+ALTER TABLE contract_types DROP COLUMN IF EXISTS waiting_period_days;
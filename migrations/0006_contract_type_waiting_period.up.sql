@@ -0,0 +1 @@
+ALTER TABLE contract_types ADD COLUMN waiting_period_days integer NOT NULL DEFAULT 0;
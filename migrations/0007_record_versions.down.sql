@@ -0,0 +1,5 @@
+ALTER TABLE contract_transfers DROP COLUMN IF EXISTS version;
+ALTER TABLE repair_orders DROP COLUMN IF EXISTS version;
+ALTER TABLE claims DROP COLUMN IF EXISTS version;
+ALTER TABLE contracts DROP COLUMN IF EXISTS version;
+ALTER TABLE contract_types DROP COLUMN IF EXISTS version;
@@ -0,0 +1,8 @@
+-- Version numbers for optimistic concurrency control. Every update checks
+-- and increments the version the record was loaded with.
+
+ALTER TABLE contract_types ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE contracts ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE claims ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE repair_orders ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE contract_transfers ADD COLUMN version bigint NOT NULL DEFAULT 1;
@@ -0,0 +1,17 @@
+-- Responses to requests sent with an Idempotency-Key, replayed to retries
+-- until expires_at. status_code is 0 while the request is still running.
+
+CREATE TABLE idempotency_records (
+    username     text NOT NULL,
+    key          text NOT NULL,
+    request_hash text NOT NULL,
+    status_code  integer NOT NULL,
+    content_type text NOT NULL,
+    etag         text NOT NULL,
+    body         bytea,
+    created_at   timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL,
+    PRIMARY KEY (username, key)
+);
+
+CREATE INDEX idx_idempotency_records_expires_at ON idempotency_records (expires_at);
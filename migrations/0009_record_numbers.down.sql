@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_claims_number;
+ALTER TABLE claims DROP COLUMN IF EXISTS number;
+DROP INDEX IF EXISTS idx_contracts_number;
+ALTER TABLE contracts DROP COLUMN IF EXISTS number;
+ALTER TABLE repair_orders DROP COLUMN IF EXISTS uuid;
+DROP TABLE IF EXISTS number_sequences;
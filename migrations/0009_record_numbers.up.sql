@@ -0,0 +1,56 @@
+-- Server-issued identifiers: repair orders get a primary key of their own,
+-- contracts and claims a number such as POL-2026-000123 counted per prefix
+-- and year in number_sequences.
+
+CREATE TABLE number_sequences (
+    prefix text NOT NULL,
+    year   integer NOT NULL,
+    value  bigint NOT NULL,
+    PRIMARY KEY (prefix, year)
+);
+
+ALTER TABLE repair_orders ADD COLUMN uuid text;
+UPDATE repair_orders SET uuid = gen_random_uuid()::text;
+ALTER TABLE repair_orders ALTER COLUMN uuid SET NOT NULL;
+ALTER TABLE repair_orders ADD PRIMARY KEY (uuid);
+
+-- Existing contracts are numbered by start date and existing claims by
+-- date, and each year's sequence continues after them
+
+ALTER TABLE contracts ADD COLUMN number text;
+WITH numbered AS (
+    SELECT uuid,
+           extract(year FROM coalesce(start_date, now()))::integer AS year,
+           row_number() OVER (PARTITION BY extract(year FROM coalesce(start_date, now())) ORDER BY start_date, uuid) AS n
+    FROM contracts
+)
+UPDATE contracts
+SET number = 'POL-' || numbered.year || '-' || lpad(numbered.n::text, greatest(6, length(numbered.n::text)), '0')
+FROM numbered
+WHERE contracts.uuid = numbered.uuid;
+ALTER TABLE contracts ALTER COLUMN number SET NOT NULL;
+CREATE UNIQUE INDEX idx_contracts_number ON contracts (number);
+
+INSERT INTO number_sequences (prefix, year, value)
+SELECT 'POL', split_part(number, '-', 2)::integer, count(*)
+FROM contracts
+GROUP BY split_part(number, '-', 2);
+
+ALTER TABLE claims ADD COLUMN number text;
+WITH numbered AS (
+    SELECT uuid,
+           extract(year FROM coalesce(date, now()))::integer AS year,
+           row_number() OVER (PARTITION BY extract(year FROM coalesce(date, now())) ORDER BY date, uuid) AS n
+    FROM claims
+)
+UPDATE claims
+SET number = 'CLM-' || numbered.year || '-' || lpad(numbered.n::text, greatest(6, length(numbered.n::text)), '0')
+FROM numbered
+WHERE claims.uuid = numbered.uuid;
+ALTER TABLE claims ALTER COLUMN number SET NOT NULL;
+CREATE UNIQUE INDEX idx_claims_number ON claims (number);
+
+INSERT INTO number_sequences (prefix, year, value)
+SELECT 'CLM', split_part(number, '-', 2)::integer, count(*)
+FROM claims
+GROUP BY split_part(number, '-', 2);
@@ -0,0 +1,10 @@
+ALTER TABLE repair_orders DROP CONSTRAINT IF EXISTS fk_claims_repair_order;
+DROP INDEX IF EXISTS idx_repair_orders_claim_uuid;
+CREATE INDEX idx_repair_orders_claim_uuid ON repair_orders (claim_uuid);
+ALTER TABLE repair_orders ALTER COLUMN claim_uuid DROP NOT NULL;
+
+ALTER TABLE claims DROP CONSTRAINT IF EXISTS fk_contracts_claims;
+ALTER TABLE claims ALTER COLUMN contract_uuid DROP NOT NULL;
+
+ALTER TABLE contracts DROP CONSTRAINT IF EXISTS fk_users_contracts;
+ALTER TABLE contracts ALTER COLUMN username DROP NOT NULL;
@@ -0,0 +1,21 @@
+-- Foreign keys for the associations between users, contracts, claims and
+-- repair orders, so that no claim can outlive its contract. Adding them
+-- fails if orphaned rows or several repair orders for one claim exist;
+-- those must be cleaned up by hand first.
+
+ALTER TABLE contracts ALTER COLUMN username SET NOT NULL;
+ALTER TABLE contracts
+    ADD CONSTRAINT fk_users_contracts FOREIGN KEY (username)
+    REFERENCES users (username) ON DELETE CASCADE;
+
+ALTER TABLE claims ALTER COLUMN contract_uuid SET NOT NULL;
+ALTER TABLE claims
+    ADD CONSTRAINT fk_contracts_claims FOREIGN KEY (contract_uuid)
+    REFERENCES contracts (uuid) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS idx_repair_orders_claim_uuid;
+ALTER TABLE repair_orders ALTER COLUMN claim_uuid SET NOT NULL;
+CREATE UNIQUE INDEX idx_repair_orders_claim_uuid ON repair_orders (claim_uuid);
+ALTER TABLE repair_orders
+    ADD CONSTRAINT fk_claims_repair_order FOREIGN KEY (claim_uuid)
+    REFERENCES claims (uuid) ON DELETE CASCADE;
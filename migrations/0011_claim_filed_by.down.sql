@@ -0,0 +1 @@
+ALTER TABLE claims DROP COLUMN IF EXISTS filed_by;
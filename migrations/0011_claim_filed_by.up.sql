@@ -0,0 +1,18 @@
+-- The customer who filed each claim, so that a contract's new owner does
+-- not see the claims of earlier owners. Existing claims take the actor of
+-- their claim.file audit entry, or failing that the owner of the contract
+-- when the claim's date fell.
+
+ALTER TABLE claims ADD COLUMN filed_by text;
+
+UPDATE claims SET filed_by = COALESCE(
+    (SELECT a.actor FROM audit_entries a
+        WHERE a.entity_type = 'claim' AND a.entity_id = claims.uuid AND a.operation = 'claim.file'
+        ORDER BY a.id LIMIT 1),
+    (SELECT t.from_username FROM contract_transfers t
+        WHERE t.contract_uuid = claims.contract_uuid AND t.status = 'accepted' AND t.resolved_at > claims.date
+        ORDER BY t.resolved_at LIMIT 1),
+    (SELECT c.username FROM contracts c WHERE c.uuid = claims.contract_uuid)
+);
+
+ALTER TABLE claims ALTER COLUMN filed_by SET NOT NULL;
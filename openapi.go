@@ -0,0 +1,403 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// apiVersion is the version reported in the OpenAPI document.
+const apiVersion = "1.0.0"
+
+//go:embed docs/index.html
+var docsPage []byte
+
+// routeDoc describes a registered route for the OpenAPI document.
+type routeDoc struct {
+	Pattern  string
+	Legacy   string
+	Public   bool
+	Request  reflect.Type
+	Response reflect.Type
+	// ContentType of the response, application/json if empty
+	ContentType string
+}
+
+// routeDocs lists the documented routes in registration order.
+var routeDocs []routeDoc
+
+// documentRoute adds a route to the OpenAPI document. Routes registered
+// through handleRoute and handlePublicRoute are documented automatically.
+func documentRoute(doc routeDoc) {
+	routeDocs = append(routeDocs, doc)
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]openAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security"`
+}
+
+type openAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Description string        `json:"description,omitempty"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+// openAPISchema is a JSON Schema object as used by OpenAPI 3.
+type openAPISchema map[string]interface{}
+
+// schemaEnums lists the values of string-encoded enums. ClaimStatus is
+// derived from its MarshalJSON so that the document shows the single-letter
+// wire encoding.
+func schemaEnums() map[reflect.Type]openAPISchema {
+	var statuses []string
+	var meanings []string
+	for s := ClaimStatusNew; s <= ClaimStatusReopened; s++ {
+		encoded, _ := s.MarshalJSON()
+		var letter string
+		json.Unmarshal(encoded, &letter)
+		statuses = append(statuses, letter)
+		meanings = append(meanings, fmt.Sprintf("%s = %s", letter, s))
+	}
+
+	roles := make([]string, len(allRoles))
+	for i, role := range allRoles {
+		roles[i] = string(role)
+	}
+
+	return map[reflect.Type]openAPISchema{
+		reflect.TypeOf(ClaimStatus(0)): {
+			"type":        "string",
+			"enum":        statuses,
+			"description": "Claim status: " + strings.Join(meanings, ", "),
+		},
+		reflect.TypeOf(Role("")): {
+			"type": "string",
+			"enum": roles,
+		},
+		reflect.TypeOf(ClaimAction("")): {
+			"type": "string",
+			"enum": []ClaimAction{
+				ClaimActionReview, ClaimActionConfirmTheft, ClaimActionDenyTheft, ClaimActionApproveRepair,
+				ClaimActionApproveReimbursement, ClaimActionReject, ClaimActionClose, ClaimActionReopen,
+			},
+		},
+		reflect.TypeOf(TransferStatus("")): {
+			"type": "string",
+			"enum": []TransferStatus{TransferStatusPending, TransferStatusAccepted, TransferStatusDeclined, TransferStatusCancelled},
+		},
+	}
+}
+
+// schemaBuilder turns Go types into schemas, collecting named types as
+// components.
+type schemaBuilder struct {
+	enums      map[reflect.Type]openAPISchema
+	components map[string]openAPISchema
+}
+
+// schemaName is the component name of a named type. Instances of generic
+// types are named after their type arguments, e.g. PageContract for
+// Page[main.Contract].
+func schemaName(t reflect.Type) string {
+	name := strings.ReplaceAll(t.Name(), t.PkgPath()+".", "")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name)
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func componentRef(name string) openAPISchema {
+	return openAPISchema{"$ref": "#/components/schemas/" + name}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) openAPISchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if enum, ok := b.enums[t]; ok {
+		name := schemaName(t)
+		b.components[name] = enum
+		return componentRef(name)
+	}
+
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return openAPISchema{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return openAPISchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return openAPISchema{"type": "string"}
+	case reflect.Bool:
+		return openAPISchema{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return openAPISchema{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return openAPISchema{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return openAPISchema{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return openAPISchema{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return openAPISchema{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return openAPISchema{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t, nil)
+		}
+		name := schemaName(t)
+		if _, ok := b.components[name]; !ok {
+			// Reserve the name first so that recursive types terminate
+			b.components[name] = openAPISchema{}
+			b.components[name] = b.object(t, nil)
+		}
+		return componentRef(name)
+	default:
+		return openAPISchema{}
+	}
+}
+
+// object builds an object schema from the JSON fields of a struct, leaving
+// out the fields named in skip.
+func (b *schemaBuilder) object(t reflect.Type, skip map[string]bool) openAPISchema {
+	properties := map[string]openAPISchema{}
+	b.addProperties(properties, t, skip)
+	return openAPISchema{"type": "object", "properties": properties}
+}
+
+func (b *schemaBuilder) addProperties(properties map[string]openAPISchema, t reflect.Type, skip map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			b.addProperties(properties, field.Type, skip)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if !skip[name] {
+			properties[name] = b.schema(field.Type)
+		}
+	}
+}
+
+// operationID derives an identifier such as get_contracts_contract_uuid_token
+// from a route pattern.
+func operationID(method, path string) string {
+	id := strings.ToLower(method) + strings.NewReplacer("/", "_", "{", "", "}", "").Replace(path)
+	return strings.TrimRight(id, "_")
+}
+
+// buildOpenAPI describes every documented route.
+func buildOpenAPI() *openAPIDocument {
+	b := &schemaBuilder{enums: schemaEnums(), components: map[string]openAPISchema{}}
+	errorSchema := b.schema(reflect.TypeOf(errorResponse{}))
+
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "NFT contract management API", Version: apiVersion},
+		Paths:   map[string]map[string]openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: b.components,
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"bearerAuth": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: `Access token from POST /sessions: a base64url JSON payload and its base64url HMAC-SHA256 signature, joined by ".".`,
+				},
+			},
+		},
+	}
+
+	for _, route := range routeDocs {
+		method, path, _ := strings.Cut(route.Pattern, " ")
+		_, resource, _ := strings.Cut(path, "/")
+		resource, _, _ = strings.Cut(resource, "/")
+
+		op := openAPIOperation{
+			OperationID: operationID(method, path),
+			Tags:        []string{resource},
+			Responses: map[string]openAPIResponse{
+				"default": {
+					Description: "Error",
+					Content:     map[string]openAPIMediaType{"application/json": {Schema: errorSchema}},
+				},
+			},
+			Security: []map[string][]string{},
+		}
+
+		var notes []string
+		if !route.Public {
+			op.Security = []map[string][]string{{"bearerAuth": {}}}
+			roles := make([]string, len(routePermissions[route.Pattern]))
+			for i, role := range routePermissions[route.Pattern] {
+				roles[i] = string(role)
+			}
+			notes = append(notes, "Allowed roles: "+strings.Join(roles, ", ")+".")
+		}
+		if route.Legacy != "" {
+			notes = append(notes, fmt.Sprintf("Also served at the deprecated route %s until %s.", route.Legacy, legacyRoutesSunset.Format(time.DateOnly)))
+		}
+		op.Description = strings.Join(notes, " ")
+
+		// Path parameters are taken from the pattern, other request fields
+		// from the query string or the body
+		pathParams := map[string]bool{}
+		for _, param := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+			pathParams[param[1]] = true
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name: param[1], In: "path", Required: true, Schema: openAPISchema{"type": "string"},
+			})
+		}
+		if route.Request != nil && reflect.PointerTo(route.Request).Implements(typeOf[conditionalRequest]()) {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name: "If-Match", In: "header", Required: true, Schema: openAPISchema{"type": "string"},
+				Description: `ETag of the record being updated, or "*" to skip the version check.`,
+			})
+		}
+		if !route.Public && method != http.MethodGet {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name: "Idempotency-Key", In: "header", Schema: openAPISchema{"type": "string", "maxLength": maxIdempotencyKeyLength},
+				Description: "Unique key making the request safe to retry: retries with the same key and request replay the first response.",
+			})
+		}
+		if route.Request != nil && route.Request.Kind() == reflect.Struct {
+			body := b.object(route.Request, pathParams)
+			properties := body["properties"].(map[string]openAPISchema)
+			if method == http.MethodGet || method == http.MethodHead {
+				names := make([]string, 0, len(properties))
+				for name := range properties {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					op.Parameters = append(op.Parameters, openAPIParameter{Name: name, In: "query", Schema: properties[name]})
+				}
+			} else if len(properties) > 0 {
+				schema := body
+				if len(pathParams) == 0 && route.Request.Name() != "" {
+					schema = b.schema(route.Request)
+				}
+				op.RequestBody = &openAPIRequestBody{
+					Required: true,
+					Content:  map[string]openAPIMediaType{"application/json": {Schema: schema}},
+				}
+			}
+		}
+
+		contentType := route.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success := openAPIResponse{Description: "Success", Content: map[string]openAPIMediaType{}}
+		if route.Response != nil {
+			success.Content[contentType] = openAPIMediaType{Schema: b.schema(route.Response)}
+			if route.Response.Implements(typeOf[taggedResponse]()) {
+				success.Headers = map[string]openAPIHeader{
+					"ETag": {Description: "Version of the record, to send in If-Match when updating it.", Schema: openAPISchema{"type": "string"}},
+				}
+			}
+		} else {
+			success.Content[contentType] = openAPIMediaType{Schema: openAPISchema{"type": "string"}}
+		}
+		op.Responses["200"] = success
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]openAPIOperation{}
+		}
+		doc.Paths[path][strings.ToLower(method)] = op
+	}
+
+	return doc
+}
+
+// openAPIHandler serves the OpenAPI document of the routes documented so
+// far, so it must be registered after them.
+func openAPIHandler() http.HandlerFunc {
+	spec, err := json.Marshal(buildOpenAPI())
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+// docsHandler serves the browsable API documentation, which renders
+// /openapi.json.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
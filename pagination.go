@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes of list endpoints. Larger limits are capped at maxPageSize.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// PageRequest holds the paging parameters shared by list endpoints. Sort
+// names a sortable field, prefixed with "-" for descending order. Cursor is
+// the next_cursor of the previous page and must be used with the same sort.
+type PageRequest struct {
+	Limit        int    `json:"limit"`
+	Cursor       string `json:"cursor"`
+	Sort         string `json:"sort"`
+	IncludeTotal bool   `json:"include_total"`
+}
+
+// Page is one page of a list. NextCursor is empty on the last page; Total
+// is only set when include_total was requested.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// pageCursor is the decoded form of a cursor: the sort it was issued for and
+// the position of the last item of its page.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Key   string `json:"k"`
+}
+
+// formatSortValue encodes a column value for a cursor.
+func formatSortValue(value interface{}) string {
+	switch value := value.(type) {
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// parseSortValue decodes a cursor value of the same type as like.
+func parseSortValue(s string, like interface{}) (interface{}, error) {
+	switch like.(type) {
+	case string:
+		return s, nil
+	case int64:
+		return strconv.ParseInt(s, 10, 64)
+	case float64:
+		return strconv.ParseFloat(s, 64)
+	case bool:
+		return strconv.ParseBool(s)
+	case time.Time:
+		return time.Parse(time.RFC3339Nano, s)
+	default:
+		return nil, fmt.Errorf("unsupported sort value %T", like)
+	}
+}
+
+// sortFields lists the fields a list can be sorted by.
+func (spec listSpec[T]) sortFields() []string {
+	fields := make([]string, 0, len(spec.Columns))
+	for column := range spec.Columns {
+		fields = append(fields, column)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// pageOptions turns the paging parameters into ListOptions for spec and
+// returns them with the page size. The limit asks for one item more than the
+// page holds, to tell whether another page follows.
+func pageOptions[T any](p PageRequest, spec listSpec[T]) (ListOptions, int, error) {
+	size := p.Limit
+	switch {
+	case size < 0:
+		return ListOptions{}, 0, validationError("invalid_limit", "limit must not be negative")
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+	opts := ListOptions{Limit: size + 1}
+
+	opts.Sort = strings.TrimPrefix(p.Sort, "-")
+	opts.Descending = strings.HasPrefix(p.Sort, "-")
+	if opts.Sort == "" {
+		opts.Sort = spec.DefaultSort
+	}
+	if _, ok := spec.Columns[opts.Sort]; !ok {
+		return ListOptions{}, 0, validationError("invalid_sort", "cannot sort by %q, expected one of %s", opts.Sort, strings.Join(spec.sortFields(), ", "))
+	}
+
+	if p.Cursor != "" {
+		after, err := decodeCursor(p, spec, opts.Sort)
+		if err != nil {
+			return ListOptions{}, 0, validationError("invalid_cursor", "invalid cursor: %v", err)
+		}
+		opts.After = after
+	}
+
+	if p.IncludeTotal {
+		opts.Total = new(int64)
+	}
+	return opts, size, nil
+}
+
+// decodeCursor decodes p.Cursor into a position in the list sorted by
+// column.
+func decodeCursor[T any](p PageRequest, spec listSpec[T], column string) (*ListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != p.Sort {
+		return nil, fmt.Errorf("cursor was issued for sort %q", cursor.Sort)
+	}
+
+	var zero T
+	value, err := parseSortValue(cursor.Value, spec.Columns[column](&zero))
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseSortValue(cursor.Key, spec.Columns[spec.Key](&zero))
+	if err != nil {
+		return nil, err
+	}
+	return &ListCursor{Value: value, Key: key}, nil
+}
+
+// encodeCursor returns the cursor resuming a list after item.
+func encodeCursor[T any](p PageRequest, spec listSpec[T], column string, item *T) string {
+	raw, _ := json.Marshal(pageCursor{
+		Sort:  p.Sort,
+		Value: formatSortValue(spec.Columns[column](item)),
+		Key:   formatSortValue(spec.Columns[spec.Key](item)),
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// listPage fetches the page p asks for through list, which applies the
+// options it is given to its filter.
+func listPage[T any](p PageRequest, spec listSpec[T], list func(opts ListOptions) ([]T, error)) (*Page[T], error) {
+	opts, size, err := pageOptions(p, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := list(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Items: items, Total: opts.Total}
+	if len(items) > size {
+		page.Items = items[:size]
+		page.NextCursor = encodeCursor(p, spec, opts.Sort, &items[size-1])
+	}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	return page, nil
+}
+
+// mapPage converts the items of a page.
+func mapPage[T, U any](page *Page[T], convert func(*T) (U, error)) (*Page[U], error) {
+	mapped := &Page[U]{Items: make([]U, 0, len(page.Items)), NextCursor: page.NextCursor, Total: page.Total}
+	for i := range page.Items {
+		item, err := convert(&page.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		mapped.Items = append(mapped.Items, item)
+	}
+	return mapped, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testContracts() []Contract {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	contracts := make([]Contract, 7)
+	for i := range contracts {
+		contracts[i] = Contract{
+			UUID:      fmt.Sprintf("contract-%d", i),
+			Number:    fmt.Sprintf("POL-2026-%06d", 7-i),
+			Username:  []string{"alice", "bob"}[i%2],
+			StartDate: start.Add(time.Duration(i/3) * time.Hour),
+			Premium:   []float32{12.34, 0.1, 12.34, 99.99, 0.1, 5, 12.34}[i],
+		}
+	}
+	return contracts
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	contract := Contract{
+		UUID:      "contract-1",
+		Number:    "POL-2026-000001",
+		Username:  "alice",
+		StartDate: time.Date(2026, 3, 1, 12, 30, 45, 123456789, cet),
+		Premium:   12.34,
+	}
+	claim := Claim{UUID: "claim-1", Date: contract.StartDate, Status: ClaimStatusReopened}
+	entry := AuditEntry{ID: 42, Timestamp: contract.StartDate}
+
+	tests := []struct {
+		name  string
+		check func(t *testing.T)
+	}{
+		{name: "string", check: func(t *testing.T) { checkCursorRoundTrip(t, contractList, "username", &contract) }},
+		{name: "descending string", check: func(t *testing.T) { checkCursorRoundTrip(t, contractList, "-number", &contract) }},
+		{name: "float", check: func(t *testing.T) { checkCursorRoundTrip(t, contractList, "premium", &contract) }},
+		{name: "time with nanoseconds and zone", check: func(t *testing.T) { checkCursorRoundTrip(t, contractList, "-start_date", &contract) }},
+		{name: "claim status", check: func(t *testing.T) { checkCursorRoundTrip(t, claimList, "status", &claim) }},
+		{name: "claim date", check: func(t *testing.T) { checkCursorRoundTrip(t, claimList, "date", &claim) }},
+		{name: "integer key", check: func(t *testing.T) { checkCursorRoundTrip(t, auditEntryList, "-id", &entry) }},
+		{name: "time with integer key", check: func(t *testing.T) { checkCursorRoundTrip(t, auditEntryList, "timestamp", &entry) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, tt.check)
+	}
+}
+
+// checkCursorRoundTrip encodes a cursor after item and checks that it
+// decodes to the item's position.
+func checkCursorRoundTrip[T any](t *testing.T, spec listSpec[T], sort string, item *T) {
+	t.Helper()
+	p := PageRequest{Sort: sort}
+	opts, _, err := pageOptions(p, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Cursor = encodeCursor(p, spec, opts.Sort, item)
+	opts, _, err = pageOptions(p, spec)
+	if err != nil {
+		t.Fatalf("decoding cursor: %v", err)
+	}
+
+	value, key := spec.Columns[opts.Sort](item), spec.Columns[spec.Key](item)
+	if compareValues(opts.After.Value, value) != 0 || compareValues(opts.After.Key, key) != 0 {
+		t.Errorf("cursor decoded to (%v, %v), want (%v, %v)", opts.After.Value, opts.After.Key, value, key)
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	contracts := testContracts()
+	valid := encodeCursor(PageRequest{Sort: "premium"}, contractList, "premium", &contracts[0])
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{name: "not base64", sort: "premium", cursor: "not a cursor!"},
+		{name: "not json", sort: "premium", cursor: encode("premium")},
+		{name: "different sort", sort: "-premium", cursor: valid},
+		{name: "default sort", sort: "", cursor: valid},
+		{name: "value of wrong type", sort: "premium", cursor: encode(`{"s":"premium","v":"cheap","k":"contract-0"}`)},
+		{name: "time of wrong format", sort: "start_date", cursor: encode(`{"s":"start_date","v":"2026-01-01","k":"contract-0"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := pageOptions(PageRequest{Sort: tt.sort, Cursor: tt.cursor}, contractList)
+			if code := errorCode(err); code != "invalid_cursor" {
+				t.Errorf("error = %v, want invalid_cursor", err)
+			}
+		})
+	}
+}
+
+func TestPageOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  PageRequest
+		wantSize int
+		wantCode string
+	}{
+		{name: "default size", request: PageRequest{}, wantSize: defaultPageSize},
+		{name: "requested size", request: PageRequest{Limit: 10}, wantSize: 10},
+		{name: "capped size", request: PageRequest{Limit: 10000}, wantSize: maxPageSize},
+		{name: "negative size", request: PageRequest{Limit: -1}, wantCode: "invalid_limit"},
+		{name: "unknown sort", request: PageRequest{Sort: "brand"}, wantCode: "invalid_sort"},
+		{name: "unknown descending sort", request: PageRequest{Sort: "-password"}, wantCode: "invalid_sort"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, size, err := pageOptions(tt.request, contractList)
+			if tt.wantCode != "" {
+				if code := errorCode(err); code != tt.wantCode {
+					t.Fatalf("error = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != tt.wantSize || opts.Limit != tt.wantSize+1 {
+				t.Errorf("size = %d, limit = %d, want %d and %d", size, opts.Limit, tt.wantSize, tt.wantSize+1)
+			}
+		})
+	}
+}
+
+// TestListPageWalk follows next_cursor through every page and checks that
+// the pages add up to the whole list in order, including across items with
+// equal sort values.
+func TestListPageWalk(t *testing.T) {
+	for _, sort := range []string{"", "uuid", "-uuid", "premium", "-premium", "username", "start_date", "-start_date", "number"} {
+		for _, limit := range []int{1, 2, 3, 7, 8} {
+			t.Run(fmt.Sprintf("%s/%d", sort, limit), func(t *testing.T) {
+				list := func(opts ListOptions) ([]Contract, error) {
+					return page(testContracts(), contractList, opts), nil
+				}
+				all, err := listPage(PageRequest{Sort: sort, Limit: maxPageSize}, contractList, list)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var walked []string
+				p := PageRequest{Sort: sort, Limit: limit}
+				for pages := 0; ; pages++ {
+					if pages > len(all.Items) {
+						t.Fatal("paging does not terminate")
+					}
+					result, err := listPage(p, contractList, list)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(result.Items) > limit {
+						t.Fatalf("page holds %d items, want at most %d", len(result.Items), limit)
+					}
+					for _, contract := range result.Items {
+						walked = append(walked, contract.UUID)
+					}
+					if result.NextCursor == "" {
+						break
+					}
+					p.Cursor = result.NextCursor
+				}
+
+				var want []string
+				for _, contract := range all.Items {
+					want = append(want, contract.UUID)
+				}
+				if !slices.Equal(walked, want) {
+					t.Errorf("walked %v, want %v", walked, want)
+				}
+			})
+		}
+	}
+}
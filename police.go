@@ -1,93 +1,154 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
-	"gorm.io/gorm"
 	//"myproject/models" // Adjust to match your project structure
 )
 
-func listTheftClaims(db *gorm.DB) ([]map[string]interface{}, error) {
+// TheftClaimView is a theft claim as shown to the police.
+type TheftClaimView struct {
+	UUID         string `json:"uuid"`
+	Number       string `json:"number"`
+	ContractUUID string `json:"contract_uuid"`
+	Item         Item   `json:"item"`
+	Description  string `json:"description"`
+	Name         string `json:"name"`
+	Version      int64  `json:"version"`
+}
+
+// ListTheftClaimsRequest filters and pages the theft claims awaiting the
+// police. Number finds a claim by its claim number. Claim dates match from
+// DateFrom inclusive to DateTo exclusive.
+type ListTheftClaimsRequest struct {
+	PageRequest
+	Number       string    `json:"number"`
+	ContractUUID string    `json:"contract_uuid"`
+	DateFrom     time.Time `json:"date_from"`
+	DateTo       time.Time `json:"date_to" validate:"after=date_from"`
+}
+
+func listTheftClaims(store Store, caller *User, input *ListTheftClaimsRequest) (*Page[TheftClaimView], error) {
 	// Query all claims marked as theft and with status "New"
-	var claims []Claim
-	if err := db.Where("is_theft = ? AND status = ?", true, ClaimStatusNew).Find(&claims).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch theft claims: %v", err)
+	isTheft := true
+	filter := ClaimFilter{
+		Number:       input.Number,
+		ContractUUID: input.ContractUUID,
+		Status:       ClaimStatusNew,
+		IsTheft:      &isTheft,
+		DateFrom:     input.DateFrom,
+		DateTo:       input.DateTo,
+	}
+	claims, err := listPage(input.PageRequest, claimList, func(opts ListOptions) ([]Claim, error) {
+		filter.ListOptions = opts
+		return store.ListClaims(filter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch theft claims: %w", err)
 	}
 
 	// Prepare results
-	results := []map[string]interface{}{}
-	for _, claim := range claims {
-		// Fetch the associated contract
-		var contract Contract
-		if err := db.Where("uuid = ?", claim.ContractUUID).First(&contract).Error; err != nil {
-			return nil, fmt.Errorf("failed to fetch contract for claim %s: %v", claim.UUID, err)
-		}
-
-		// Fetch the associated user
-		var user User
-		if err := db.Where("username = ?", contract.Username).First(&user).Error; err != nil {
-			return nil, fmt.Errorf("failed to fetch user for contract %s: %v", contract.UUID, err)
-		}
+	return mapPage(claims, func(claim *Claim) (TheftClaimView, error) {
+		return theftClaimView(store, claim)
+	})
+}
 
-		// Construct the result
-		result := map[string]interface{}{
-			"uuid":          claim.UUID,
-			"contract_uuid": claim.ContractUUID,
-			"item":          contract.Item,
-			"description":   claim.Description,
-			"name":          fmt.Sprintf("%s %s", user.FirstName, user.LastName),
-		}
+// theftClaimView shows a claim to the police together with the stolen item
+// and its owner.
+func theftClaimView(store Store, claim *Claim) (TheftClaimView, error) {
+	// Fetch the associated contract
+	contract, err := claim.Contract(store)
+	if err != nil {
+		return TheftClaimView{}, fmt.Errorf("failed to fetch contract for claim %s: %w", claim.UUID, err)
+	}
 
-		
-		results = append(results, result)
+	// Fetch the associated user
+	user, err := contract.User(store)
+	if err != nil {
+		return TheftClaimView{}, fmt.Errorf("failed to fetch user for contract %s: %w", contract.UUID, err)
 	}
 
-	return results, nil
+	// Construct the result
+	return TheftClaimView{
+		UUID:         claim.UUID,
+		Number:       claim.Number,
+		ContractUUID: claim.ContractUUID,
+		Item:         contract.Item,
+		Description:  claim.Description,
+		Name:         fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Version:      claim.Version,
+	}, nil
 }
 
+// TheftClaimRequest identifies a theft claim.
+type TheftClaimRequest struct {
+	UUID string `json:"uuid" validate:"required"`
+}
 
-
-func processTheftClaim(db *gorm.DB, args string) error {
-	// Parse input arguments
-	var dto struct {
-		UUID          string `json:"uuid"`
-		ContractUUID  string `json:"contract_uuid"`
-		IsTheft       bool   `json:"is_theft"`
-		FileReference string `json:"file_reference"`
+// getTheftClaim returns one theft claim, with its version as ETag.
+func getTheftClaim(store Store, caller *User, input *TheftClaimRequest) (*TheftClaimView, error) {
+	claim, err := store.GetClaim(input.UUID)
+	if err == nil && !claim.IsTheft {
+		err = ErrNotFound
 	}
-	if err := json.Unmarshal([]byte(args), &dto); err != nil {
-		return fmt.Errorf("invalid input: %v", err)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("claim_not_found", "theft claim not found for UUID: %s", input.UUID)
+		}
+		return nil, fmt.Errorf("failed to fetch claim: %w", err)
 	}
 
-	// Fetch the claim
-	var claim Claim
-	if err := db.Where("uuid = ? AND contract_uuid = ?", dto.UUID, dto.ContractUUID).First(&claim).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("claim not found for UUID: %s", dto.UUID)
-		}
-		return fmt.Errorf("failed to fetch claim: %v", err)
+	view, err := theftClaimView(store, claim)
+	if err != nil {
+		return nil, err
 	}
+	return &view, nil
+}
+
+
+
+// ProcessTheftClaimRequest records the police's verdict on a theft claim.
+type ProcessTheftClaimRequest struct {
+	IfMatch
+	UUID          string `json:"uuid" validate:"required"`
+	ContractUUID  string `json:"contract_uuid"`
+	IsTheft       bool   `json:"is_theft"`
+	FileReference string `json:"file_reference"`
+}
 
-	// Check if the claim is theft-related and in a valid state
-	if !claim.IsTheft || claim.Status != ClaimStatusNew {
-		return errors.New("claim is either not related to theft or has an invalid status")
+func processTheftClaim(store Store, caller *User, dto *ProcessTheftClaimRequest) (*TheftClaimView, error) {
+	// Fetch and lock the claim
+	claim, err := store.GetClaimForUpdate(dto.UUID)
+	if err == nil && dto.ContractUUID != "" && claim.ContractUUID != dto.ContractUUID {
+		err = ErrNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("claim_not_found", "claim not found for UUID: %s", dto.UUID)
+		}
+		return nil, fmt.Errorf("failed to fetch claim: %w", err)
+	}
+	if err := dto.check(claim.Version); err != nil {
+		return nil, err
 	}
 
-	// Update the claim's status based on theft confirmation
+	// Confirm or deny the theft
+	action := ClaimActionDenyTheft
 	if dto.IsTheft {
-		claim.Status = ClaimStatusTheftConfirmed
-	} else {
-		claim.Status = ClaimStatusRejected
+		action = ClaimActionConfirmTheft
 	}
-	claim.FileReference = dto.FileReference
 
-	// Save the updated claim
-	if err := db.Save(&claim).Error; err != nil {
-		return fmt.Errorf("failed to update claim: %v", err)
+	err = applyClaimTransition(store, caller, claim, action, claimTransitionParams{
+		FileReference: dto.FileReference,
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return nil
+	view, err := theftClaimView(store, claim)
+	if err != nil {
+		return nil, err
+	}
+	return &view, nil
 }
-
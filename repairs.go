@@ -1,81 +1,134 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 
 	//"myproject/models" // Import the data package
-	"gorm.io/gorm"
 )
 
-func listRepairOrders(db *gorm.DB) ([]map[string]interface{}, error) {
-	var repairOrders []RepairOrder
+// RepairOrderView is a repair order as shown to repair shops.
+type RepairOrderView struct {
+	UUID         string `json:"uuid"`
+	ClaimUUID    string `json:"claim_uuid"`
+	ContractUUID string `json:"contract_uuid"`
+	Item         Item   `json:"item"`
+	Version      int64  `json:"version"`
+}
+
+// ListRepairOrdersRequest filters and pages the open repair orders.
+type ListRepairOrdersRequest struct {
+	PageRequest
+	ContractUUID string `json:"contract_uuid"`
+}
 
+func listRepairOrders(store Store, caller *User, input *ListRepairOrdersRequest) (*Page[RepairOrderView], error) {
 	// Query all repair orders where Ready is false
-	err := db.Where("ready = ?", false).Find(&repairOrders).Error
+	ready := false
+	filter := RepairOrderFilter{Ready: &ready, ContractUUID: input.ContractUUID}
+	repairOrders, err := listPage(input.PageRequest, repairOrderList, func(opts ListOptions) ([]RepairOrder, error) {
+		filter.ListOptions = opts
+		return store.ListRepairOrders(filter)
+	})
 	if err != nil {
-		return nil, errors.New("failed to fetch repair orders: " + err.Error())
+		return nil, fmt.Errorf("failed to fetch repair orders: %w", err)
 	}
 
 	// Prepare results
-	results := []map[string]interface{}{}
-	for _, ro := range repairOrders {
-
-		derivedUUID := ro.ClaimUUID
-
-		result := map[string]interface{}{
-			"uuid":          derivedUUID,
-			"claim_uuid":    ro.ClaimUUID,
-			"contract_uuid": ro.ContractUUID,
-			"item":          ro.Item,
-		}
-		results = append(results, result)
+	return mapPage(repairOrders, func(ro *RepairOrder) (RepairOrderView, error) {
+		return repairOrderView(ro), nil
+	})
+}
+
+func repairOrderView(ro *RepairOrder) RepairOrderView {
+	return RepairOrderView{
+		UUID:         ro.UUID,
+		ClaimUUID:    ro.ClaimUUID,
+		ContractUUID: ro.ContractUUID,
+		Item:         ro.Item,
+		Version:      ro.Version,
 	}
+}
 
-	return results, nil
+// RepairOrderRequest identifies a repair order.
+type RepairOrderRequest struct {
+	UUID string `json:"uuid" validate:"required"`
 }
 
-func completeRepairOrder(db *gorm.DB, args string) error {
-	// Parse input JSON
-	input := struct {
-		UUID string `json:"uuid"`
-	}{}
-	err := json.Unmarshal([]byte(args), &input)
-	if err != nil {
-		return errors.New("invalid input: " + err.Error())
+// getRepairOrder returns one repair order, with its version as ETag.
+func getRepairOrder(store Store, caller *User, input *RepairOrderRequest) (*RepairOrderView, error) {
+	repairOrder, err := store.GetRepairOrder(input.UUID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, notFoundError("repair_order_not_found", "repair order not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch repair order: %w", err)
 	}
 
-	// Fetch the repair order
-	var repairOrder RepairOrder
-	err = db.Where("uuid = ?", input.UUID).First(&repairOrder).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return errors.New("repair order not found")
+	view := repairOrderView(repairOrder)
+	return &view, nil
+}
+
+// CompleteRepairOrderRequest identifies the repair order to mark ready.
+type CompleteRepairOrderRequest struct {
+	IfMatch
+	UUID string `json:"uuid" validate:"required"`
+}
+
+func completeRepairOrder(store Store, caller *User, input *CompleteRepairOrderRequest) (*RepairOrderView, error) {
+	// Find the claim the repair order belongs to
+	repairOrder, err := store.GetRepairOrder(input.UUID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, notFoundError("repair_order_not_found", "repair order not found")
 	} else if err != nil {
-		return errors.New("failed to fetch repair order: " + err.Error())
+		return nil, fmt.Errorf("failed to fetch repair order: %w", err)
+	}
+
+	// Lock the claim before its repair order, in the same order as claim
+	// transitions that reopen the order, so that the two cannot deadlock
+	claim, err := store.GetClaimForUpdate(repairOrder.ClaimUUID)
+	if err == nil {
+		repairOrder, err = store.GetRepairOrderByClaimForUpdate(claim.UUID)
+	}
+	if err == nil && repairOrder.UUID != input.UUID {
+		err = ErrNotFound
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, notFoundError("repair_order_not_found", "repair order not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch repair order: %w", err)
+	}
+	if err := input.check(repairOrder.Version); err != nil {
+		return nil, err
+	}
+	if repairOrder.Ready {
+		return nil, conflictError("repair_order_ready", "repair order %s is already ready", repairOrder.UUID)
 	}
 
 	// Mark the repair order as ready
+	beforeOrder := *repairOrder
 	repairOrder.Ready = true
-	err = db.Save(&repairOrder).Error
+	err = store.SaveRepairOrder(repairOrder)
 	if err != nil {
-		return errors.New("failed to update repair order: " + err.Error())
+		return nil, fmt.Errorf("failed to update repair order: %w", err)
+	}
+	err = recordAudit(store, caller.Username, "repair_order.complete", "repair_order", repairOrder.UUID, beforeOrder, repairOrder)
+	if err != nil {
+		return nil, err
 	}
 
 	// Update the associated claim
-	var claim Claim
-	err = db.Where("contract_uuid = ? AND uuid = ?", repairOrder.ContractUUID, repairOrder.ClaimUUID).First(&claim).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		// No claim found; skip updating claim
-		return nil
-	} else if err != nil {
-		return errors.New("failed to fetch associated claim: " + err.Error())
+	beforeClaim := *claim
+	claim.Repaired = true
+	err = store.SaveClaim(claim)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update associated claim: %w", err)
 	}
 
-	claim.Repaired = true
-	err = db.Save(&claim).Error
+	err = recordAudit(store, caller.Username, "claim.repaired", "claim", claim.UUID, beforeClaim, claim)
 	if err != nil {
-		return errors.New("failed to update associated claim: " + err.Error())
+		return nil, err
 	}
 
-	return nil
+	view := repairOrderView(repairOrder)
+	return &view, nil
 }
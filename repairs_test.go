@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestCompleteRepairOrder(t *testing.T) {
+	insurer := &User{Username: "insurer", Role: RoleInsurer}
+	shop := &User{Username: "shop", Role: RoleRepairShop}
+
+	store := newMemoryStore()
+	claim := newTestClaim(t, store, Claim{Status: ClaimStatusUnderReview})
+	if err := applyClaimTransition(store, insurer, claim, ClaimActionApproveRepair, claimTransitionParams{}); err != nil {
+		t.Fatal(err)
+	}
+	order, err := store.GetRepairOrderByClaim(claim.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	complete := func(uuid string, version int64) error {
+		view, err := completeRepairOrder(store, shop, &CompleteRepairOrderRequest{IfMatch: IfMatch{Version: version}, UUID: uuid})
+		if err == nil && view.ETag() != etag(version+1) && version != 0 {
+			t.Errorf("completed order has ETag %s, want %s", view.ETag(), etag(version+1))
+		}
+		return err
+	}
+	if code := errorCode(complete("unknown", 0)); code != "repair_order_not_found" {
+		t.Errorf("completing an unknown order: code %q, want repair_order_not_found", code)
+	}
+	if code := errorCode(complete(order.UUID, order.Version+1)); code != "version_mismatch" {
+		t.Errorf("completing with a stale version: code %q, want version_mismatch", code)
+	}
+	if err := complete(order.UUID, order.Version); err != nil {
+		t.Fatalf("completing the order: %v", err)
+	}
+
+	order, _ = store.GetRepairOrder(order.UUID)
+	claim, _ = store.GetClaim(claim.UUID)
+	if !order.Ready || !claim.Repaired {
+		t.Errorf("order ready %v, claim repaired %v, want both", order.Ready, claim.Repaired)
+	}
+
+	if code := errorCode(complete(order.UUID, 0)); code != "repair_order_ready" {
+		t.Errorf("completing a ready order: code %q, want repair_order_ready", code)
+	}
+
+	// Approving the reopened claim for repair again reopens the same order
+	claim.Status = ClaimStatusReopened
+	if err := store.SaveClaim(claim); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyClaimTransition(store, insurer, claim, ClaimActionApproveRepair, claimTransitionParams{}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := store.GetRepairOrderByClaim(claim.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.UUID != order.UUID || reopened.Ready {
+		t.Errorf("repair order after reopening = %+v, want %s open again", reopened, order.UUID)
+	}
+	if err := complete(order.UUID, 0); err != nil {
+		t.Errorf("completing the reopened order: %v", err)
+	}
+}
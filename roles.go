@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// Role identifies which of the actors in the insurance workflow a user is.
+type Role string
+
+const (
+	RoleCustomer   Role = "customer"
+	RoleMerchant   Role = "merchant"
+	RoleInsurer    Role = "insurer"
+	RolePolice     Role = "police"
+	RoleRepairShop Role = "repair_shop"
+)
+
+var allRoles = []Role{RoleCustomer, RoleMerchant, RoleInsurer, RolePolice, RoleRepairShop}
+
+func (r Role) Valid() bool {
+	return slices.Contains(allRoles, r)
+}
+
+// routePermissions maps each protected route pattern to the roles allowed to
+// call it. Routes missing from this table cannot be registered with protect.
+var routePermissions = map[string][]Role{
+	"GET /contract_types":                       {RoleMerchant, RoleInsurer},
+	"POST /contract_types":                      {RoleInsurer},
+	"GET /contract_types/{uuid}":                {RoleMerchant, RoleInsurer},
+	"PATCH /contract_types/{uuid}":              {RoleInsurer},
+	"GET /contract_types/{uuid}/eligibility":    {RoleMerchant},
+	"GET /contracts":                            {RoleCustomer, RoleInsurer},
+	"POST /contracts":                           {RoleMerchant},
+	"POST /quotes":                              {RoleMerchant},
+	"GET /contracts/{contract_uuid}/provenance": {RoleCustomer, RoleInsurer},
+	"GET /contracts/{contract_uuid}/token":      allRoles,
+	"GET /claims":                               {RoleInsurer},
+	"POST /claims":                              {RoleCustomer},
+	"GET /claims/{uuid}":                        {RoleInsurer},
+	"PATCH /claims/{uuid}":                      {RoleInsurer},
+	"GET /claims/{uuid}/actions":                {RoleInsurer, RolePolice},
+	"GET /theft_claims":                         {RolePolice},
+	"GET /theft_claims/{uuid}":                  {RolePolice},
+	"PATCH /theft_claims/{uuid}":                {RolePolice},
+	"GET /repair_orders":                        {RoleRepairShop},
+	"GET /repair_orders/{uuid}":                 {RoleRepairShop},
+	"PATCH /repair_orders/{uuid}":               {RoleRepairShop},
+	"POST /users":                               {RoleInsurer},
+	"GET /users/me":                             allRoles,
+	"PUT /users/me/password":                    allRoles,
+	"GET /transfers":                            {RoleCustomer},
+	"POST /transfers":                           {RoleCustomer},
+	"GET /transfers/{uuid}":                     {RoleCustomer},
+	"POST /transfers/{uuid}/accept":             {RoleCustomer},
+	"POST /transfers/{uuid}/cancel":             {RoleCustomer},
+	"GET /tokens":                               allRoles,
+	"GET /tokens/{token_id}":                    allRoles,
+	"GET /tokens/{token_id}/verification":       allRoles,
+	"GET /audit_entries":                        {RoleInsurer},
+	"GET /audit_entries/verification":           {RoleInsurer},
+}
+
+// protect wraps a handler so that it requires a session whose user has one
+// of the roles listed for the route in routePermissions.
+func protect(store Store, route string, next http.HandlerFunc) http.HandlerFunc {
+	roles, ok := routePermissions[route]
+	if !ok {
+		log.Fatalf("No permissions defined for route %s", route)
+	}
+
+	return requireSession(store, func(w http.ResponseWriter, r *http.Request) {
+		caller := callerFromContext(r.Context())
+		if !slices.Contains(roles, caller.Role) {
+			writeError(w, forbiddenError("role_not_allowed", "role %q is not allowed to call %s", caller.Role, route))
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// bootstrapInsurer creates the initial insurer account from the
+// insurer_username and insurer_password settings so that other staff
+// accounts can be created through /user_create.
+func bootstrapInsurer(store Store, username, password string) {
+	if username == "" || password == "" {
+		return
+	}
+
+	_, err := store.GetUser(username)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, ErrNotFound) {
+		log.Fatalf("Failed to look up insurer account: %v", err)
+	}
+
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		log.Fatalf("Failed to hash insurer password: %v", err)
+	}
+	user := User{Username: username, Password: hashedPassword, Role: RoleInsurer}
+	err = store.Transaction(func(tx Store) error {
+		if err := tx.CreateUser(&user); err != nil {
+			return fmt.Errorf("failed to create insurer account: %w", err)
+		}
+		return recordAudit(tx, auditActorSystem, "user.create", "user", user.Username, nil, user)
+	})
+	if err != nil {
+		log.Fatalf("Failed to bootstrap insurer account: %v", err)
+	}
+
+	log.Printf("Created insurer account %s", username)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Legacy verb-style routes such as /contract_ls remain as aliases of the
+// resource routes until legacyRoutesSunset. Responses on them carry
+// Deprecation, Sunset and Link headers pointing at the replacement.
+var (
+	legacyRoutesDeprecated = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	legacyRoutesSunset     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+var pathParamPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Handler serves one route. Req is decoded from the request arguments, see
+// requestArgs, and the returned Resp is encoded as the JSON response. caller
+// is the authenticated user, or nil on public routes.
+type Handler[Req, Resp any] func(store Store, caller *User, req *Req) (Resp, error)
+
+// EmptyRequest is the request of handlers that take no arguments.
+type EmptyRequest struct{}
+
+// transactional runs fn in a store transaction unless pattern is a GET
+// route, so that every operation changing data commits or rolls back as a
+// whole.
+func transactional[Req, Resp any](pattern string, fn Handler[Req, Resp]) Handler[Req, Resp] {
+	if strings.HasPrefix(pattern, http.MethodGet+" ") {
+		return fn
+	}
+	return func(store Store, caller *User, req *Req) (Resp, error) {
+		var resp Resp
+		err := store.Transaction(func(tx Store) error {
+			var err error
+			resp, err = fn(tx, caller, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// requestValidator is implemented by requests with rules their validate
+// tags cannot express. It runs after the tags are checked.
+type requestValidator interface {
+	Validate() error
+}
+
+// registeredRoutes records every pattern registered, for verifyRoutes.
+var registeredRoutes = map[string]bool{}
+
+// handleRoute registers a protected resource route such as
+// "GET /contracts/{uuid}" and, if legacy is not empty, the deprecated route
+// it replaces. Both share the roles listed for pattern in routePermissions.
+func handleRoute[Req, Resp any](store Store, pattern, legacy string, fn Handler[Req, Resp]) {
+	checkRoute[Req](pattern, fn)
+	documentRoute(routeDoc{Pattern: pattern, Legacy: legacy, Request: typeOf[Req](), Response: typeOf[Resp]()})
+	registerRoute(pattern, legacy, protect(store, pattern, idempotent(store, pattern, serveHandler(store, transactional(pattern, fn)))))
+}
+
+// handlePublicRoute registers a route that needs no session. It ignores
+// Idempotency-Key, see idempotent.
+func handlePublicRoute[Req, Resp any](store Store, pattern, legacy string, fn Handler[Req, Resp]) {
+	checkRoute[Req](pattern, fn)
+	documentRoute(routeDoc{Pattern: pattern, Legacy: legacy, Public: true, Request: typeOf[Req](), Response: typeOf[Resp]()})
+	registerRoute(pattern, legacy, serveHandler(store, transactional(pattern, fn)))
+}
+
+// registerRoute registers handler for pattern and its legacy alias.
+func registerRoute(pattern, legacy string, handler http.HandlerFunc) {
+	if registeredRoutes[pattern] {
+		log.Fatalf("Route %s is registered twice", pattern)
+	}
+	registeredRoutes[pattern] = true
+
+	http.HandleFunc(pattern, handler)
+	if legacy != "" {
+		http.HandleFunc(legacy, deprecatedRoute(pattern, handler))
+	}
+}
+
+// checkRoute makes sure at startup that fn can serve pattern: the handler
+// must be set, the validate tags of its request must parse and every path
+// parameter must fill a field of the request.
+func checkRoute[Req any](pattern string, fn interface{}) {
+	if reflect.ValueOf(fn).IsNil() {
+		log.Fatalf("Route %s has no handler", pattern)
+	}
+	if err := checkValidationRules(typeOf[Req]()); err != nil {
+		log.Fatalf("Route %s: %v", pattern, err)
+	}
+	fields := requestFields(typeOf[Req]())
+	for _, param := range pathParamPattern.FindAllStringSubmatch(pattern, -1) {
+		if _, ok := fields[param[1]]; !ok {
+			log.Fatalf("Route %s: request %T has no field for path parameter %q", pattern, *new(Req), param[1])
+		}
+	}
+}
+
+// typeOf returns the reflect.Type of T, even for interface types.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// requestFields returns the types of the fields of a request struct by
+// their JSON names.
+func requestFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for name, fieldType := range requestFields(field.Type) {
+				fields[name] = fieldType
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// queryValue encodes a query string value for a field of type t. Numbers
+// and booleans are passed as JSON literals so that ?limit=10 fills an int;
+// everything else, including types that decode themselves, is a string.
+func queryValue(value string, t reflect.Type) json.RawMessage {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t != nil && !reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		var err error
+		switch t.Kind() {
+		case reflect.Bool:
+			_, err = strconv.ParseBool(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			_, err = strconv.ParseFloat(value, 64)
+		default:
+			err = strconv.ErrSyntax
+		}
+		if err == nil {
+			return json.RawMessage(value)
+		}
+	}
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
+
+// verifyRoutes makes sure at startup that every route given permissions in
+// routePermissions has been registered.
+func verifyRoutes() {
+	var missing []string
+	for pattern := range routePermissions {
+		if !registeredRoutes[pattern] {
+			missing = append(missing, pattern)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		log.Fatalf("Routes without a handler: %s", strings.Join(missing, ", "))
+	}
+}
+
+// serveHandler decodes the request for fn, calls it and writes its response.
+func serveHandler[Req, Resp any](store Store, fn Handler[Req, Resp]) http.HandlerFunc {
+	fields := requestFields(typeOf[Req]())
+	return func(w http.ResponseWriter, r *http.Request) {
+		args, err := requestArgs(r, fields)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		req := new(Req)
+		if strings.TrimSpace(args) != "" {
+			if err := json.Unmarshal([]byte(args), req); err != nil {
+				writeError(w, invalidInput(err))
+				return
+			}
+		}
+		if err := validateRequest(req); err != nil {
+			writeError(w, err)
+			return
+		}
+		if v, ok := interface{}(req).(requestValidator); ok {
+			if err := v.Validate(); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if c, ok := interface{}(req).(conditionalRequest); ok {
+			if *c.ifMatch(), err = parseIfMatch(r); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		resp, err := fn(storeFromContext(r.Context(), store), callerFromContext(r.Context()), req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if tagged, ok := interface{}(resp).(taggedResponse); ok {
+			w.Header().Set("ETag", tagged.ETag())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// deprecatedRoute serves a legacy route with the headers announcing its
+// replacement by pattern.
+func deprecatedRoute(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	_, successor, _ := strings.Cut(pattern, " ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyRoutesDeprecated.Unix()))
+		w.Header().Set("Sunset", legacyRoutesSunset.Format(http.TimeFormat))
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		handler(w, r)
+	}
+}
+
+// requestArgs builds the JSON arguments for a handler from the request
+// body, query string and path parameters. Path parameters take precedence
+// over query parameters, which take precedence over body fields. Requests
+// without query or path parameters pass the body through unchanged. fields
+// are the request fields by JSON name, see queryValue.
+func requestArgs(r *http.Request, fields map[string]reflect.Type) (string, error) {
+	var body []byte
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Body != nil {
+		defer r.Body.Close()
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return "", validationError("unreadable_body", "failed to read request body: %v", err)
+		}
+	}
+
+	query := r.URL.Query()
+	pathParams := pathParamPattern.FindAllStringSubmatch(r.Pattern, -1)
+	if len(query) == 0 && len(pathParams) == 0 {
+		return string(body), nil
+	}
+
+	args := map[string]json.RawMessage{}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &args); err != nil {
+			return "", validationError("invalid_input", "invalid input: request body must be a JSON object")
+		}
+	}
+	for name, values := range query {
+		args[name] = queryValue(values[0], fields[name])
+	}
+	for _, param := range pathParams {
+		args[param[1]], _ = json.Marshal(r.PathValue(param[1]))
+	}
+
+	merged, err := json.Marshal(args)
+	return string(merged), err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUpdateETag checks that updating routes return the record they
+// updated with its new ETag, so that clients can chain updates without
+// fetching the record again.
+func TestUpdateETag(t *testing.T) {
+	insurer := &User{Username: "insurer", Role: RoleInsurer}
+	store := newMemoryStore()
+	claim := newTestClaim(t, store, Claim{Status: ClaimStatusNew})
+
+	tests := []struct {
+		name    string
+		pattern string
+		handler http.HandlerFunc
+		path    string
+		bodies  []string
+	}{
+		{
+			name:    "contract type",
+			pattern: "PATCH /contract_types/{uuid}",
+			handler: serveHandler(store, transactional("PATCH /contract_types/{uuid}", setActiveContractType)),
+			path:    "/contract_types/type-1",
+			bodies:  []string{`{"active":false}`, `{"active":true}`},
+		},
+		{
+			name:    "claim",
+			pattern: "PATCH /claims/{uuid}",
+			handler: serveHandler(store, transactional("PATCH /claims/{uuid}", processClaim)),
+			path:    "/claims/" + claim.UUID,
+			bodies:  []string{`{"action":"review"}`, `{"action":"reject"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc(tt.pattern, tt.handler)
+
+			tag := etag(1)
+			for i, body := range tt.bodies {
+				r := httptest.NewRequest(http.MethodPatch, tt.path, strings.NewReader(body))
+				r.Header.Set("If-Match", tag)
+				r = r.WithContext(context.WithValue(r.Context(), callerKey, insurer))
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, r)
+
+				want := etag(int64(i + 2))
+				if w.Code != http.StatusOK || w.Header().Get("ETag") != want {
+					t.Fatalf("update %d: %d %s with ETag %q, want 200 with %s", i+1, w.Code, w.Body, w.Header().Get("ETag"), want)
+				}
+				if !strings.Contains(w.Body.String(), `"version":`+strings.Trim(want, `"`)) {
+					t.Errorf("update %d: body %s, want the updated record", i+1, w.Body)
+				}
+				tag = w.Header().Get("ETag")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// Session is returned by /user_authenticate and /user_refresh. The access
+// token is sent as "Authorization: Bearer <token>"; the refresh token can be
+// exchanged for a new session before it expires.
+type Session struct {
+	Username         string    `json:"username"`
+	TokenType        string    `json:"token_type"`
+	AccessToken      string    `json:"access_token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+type sessionClaims struct {
+	Username  string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type contextKey string
+
+const callerKey contextKey = "caller"
+
+// issueSession signs a new access and refresh token pair for the user.
+func issueSession(username string) (*Session, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	session := &Session{
+		Username:         username,
+		TokenType:        "Bearer",
+		ExpiresAt:        now.Add(accessTokenTTL),
+		RefreshExpiresAt: now.Add(refreshTokenTTL),
+	}
+
+	var err error
+	session.AccessToken, err = signPayload("access", sessionClaims{
+		Username:  username,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: session.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	session.RefreshToken, err = signPayload("refresh", sessionClaims{
+		Username:  username,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: session.RefreshExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign refresh token: %w", err)
+	}
+
+	return session, nil
+}
+
+// verifySessionToken checks a token's signature and expiry and returns the
+// username it was issued to.
+func verifySessionToken(purpose, token string) (string, error) {
+	var claims sessionClaims
+	if err := verifyPayload(purpose, token, &claims); err != nil {
+		return "", err
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return "", errors.New("token has expired")
+	}
+	if claims.Username == "" {
+		return "", errors.New("token has no subject")
+	}
+	return claims.Username, nil
+}
+
+// RefreshSessionRequest carries the refresh token of a session.
+type RefreshSessionRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// refreshSession exchanges a valid refresh token for a new session.
+func refreshSession(store Store, caller *User, input *RefreshSessionRequest) (*Session, error) {
+	username, err := verifySessionToken("refresh", input.RefreshToken)
+	if err != nil {
+		return nil, unauthorizedError("invalid_refresh_token", "invalid refresh token: %v", err)
+	}
+
+	// Make sure the user still exists
+	user, err := store.GetUser(username)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, unauthorizedError("invalid_refresh_token", "invalid refresh token: user no longer exists")
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	return issueSession(user.Username)
+}
+
+// requireSession resolves the caller from the bearer token and stores it in
+// the request context for serveHandler. Requests without a valid session
+// are rejected with 401.
+func requireSession(store Store, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || token == "" {
+			unauthorized(w, "missing bearer token")
+			return
+		}
+
+		username, err := verifySessionToken("access", token)
+		if err != nil {
+			unauthorized(w, err.Error())
+			return
+		}
+
+		user, err := store.GetUser(username)
+		if err != nil {
+			unauthorized(w, "unknown user")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey, user)))
+	}
+}
+
+// callerFromContext returns the authenticated user, or nil if the request
+// did not go through requireSession.
+func callerFromContext(ctx context.Context) *User {
+	caller, _ := ctx.Value(callerKey).(*User)
+	return caller
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	writeError(w, unauthorizedError("unauthorized", "%s", message))
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
 // HashPassword hashes a plaintext password using bcrypt.
@@ -18,107 +18,348 @@ func HashPassword(password string) (string, error) {
 	return string(hashedPassword), nil
 }
 
-// CreateContract creates a contract, ensuring the password is hashed before creating a user.
-func createContract(db *gorm.DB, args string) (*Contract, error) {
-	// Parse the input JSON
-	dto := struct {
-		UUID             string        `json:"uuid"`
-		ContractTypeUUID string        `json:"contract_type_uuid"`
-		Username         string        `json:"username"`
-		Password         string        `json:"password"`
-		FirstName        string        `json:"first_name"`
-		LastName         string        `json:"last_name"`
-		Item             Item          `json:"item"`
-		StartDate        time.Time     `json:"start_date"`
-		EndDate          time.Time     `json:"end_date"`
-	}{}
-
-	err := json.Unmarshal([]byte(args), &dto)
+// CheckPassword reports whether a plaintext password matches a bcrypt hash.
+func CheckPassword(hashedPassword, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+// quoteValidity is how long a quote can be bound to a contract after it was issued.
+const quoteValidity = 15 * time.Minute
+
+// Quote is a signed price for a prospective contract. Token covers every
+// other field, so createContract can bind to the quote without recomputing it.
+type Quote struct {
+	ContractTypeUUID string    `json:"contract_type_uuid"`
+	Item             Item      `json:"item"`
+	StartDate        time.Time `json:"start_date"`
+	EndDate          time.Time `json:"end_date"`
+	Days             int32     `json:"days"`
+	Premium          float32   `json:"premium"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	Token            string    `json:"token,omitempty"`
+}
+
+// coverageProblems lists every reason why a shop of shopType cannot sell a
+// contract of contractType for item over the given period.
+func coverageProblems(contractType ContractType, shopType string, item Item, startDate, endDate time.Time) []*Error {
+	var problems []*Error
+	if !contractType.Active {
+		problems = append(problems, newError(ErrorKindConflict, "contract_type_inactive", "contract type is not active"))
+	}
+
+	if shopType == "" {
+		problems = append(problems, newError(ErrorKindForbidden, "shop_type_mismatch", "contract type is sold by %s shops and the merchant has no shop type", contractType.ShopType))
+	} else if !strings.EqualFold(shopType, contractType.ShopType) {
+		problems = append(problems, newError(ErrorKindForbidden, "shop_type_mismatch", "contract type is sold by %s shops, not %s shops", contractType.ShopType, shopType))
+	}
+
+	days := contractDurationDays(startDate, endDate)
+	switch {
+	case days <= 0:
+		problems = append(problems, newError(ErrorKindValidation, "invalid_period", "end date must be after start date"))
+	case days < contractType.MinDurationDays:
+		problems = append(problems, newError(ErrorKindValidation, "duration_out_of_range", "contract duration of %d days is below the minimum of %d days", days, contractType.MinDurationDays))
+	case contractType.MaxDurationDays > 0 && days > contractType.MaxDurationDays:
+		problems = append(problems, newError(ErrorKindValidation, "duration_out_of_range", "contract duration of %d days exceeds the maximum of %d days", days, contractType.MaxDurationDays))
+	}
+
+	if contractType.MaxSumInsured > 0 && item.Price > contractType.MaxSumInsured {
+		problems = append(problems, newError(ErrorKindValidation, "sum_insured_exceeded", "item price %.2f exceeds the maximum sum insured of %.2f", item.Price, contractType.MaxSumInsured))
+	}
+
+	return problems
+}
+
+// checkCoverage verifies that an item and contract period fall within the
+// limits of a contract type and that the shop may sell it, returning the
+// first problem found.
+func checkCoverage(contractType ContractType, shopType string, item Item, startDate, endDate time.Time) error {
+	if problems := coverageProblems(contractType, shopType, item, startDate, endDate); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// EligibilityRequest describes a prospective sale to check against a
+// contract type.
+type EligibilityRequest struct {
+	UUID      string    `json:"uuid" validate:"required"`
+	Price     float32   `json:"price" validate:"min=0"`
+	StartDate time.Time `json:"start_date" validate:"required"`
+	EndDate   time.Time `json:"end_date" validate:"required"`
+}
+
+// Eligibility tells whether the calling merchant can sell a contract of a
+// contract type, and if not, every reason why.
+type Eligibility struct {
+	ContractTypeUUID string              `json:"contract_type_uuid"`
+	Eligible         bool                `json:"eligible"`
+	Reasons          []EligibilityReason `json:"reasons"`
+}
+
+// EligibilityReason is one rule a prospective sale breaks. Code is the error
+// code createContract would reject the sale with.
+type EligibilityReason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// checkEligibility runs the coverage rules of a contract type against a
+// prospective sale by the caller without creating anything.
+func checkEligibility(store Store, caller *User, input *EligibilityRequest) (*Eligibility, error) {
+	contractType, err := store.GetContractType(input.UUID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, notFoundError("contract_type_not_found", "contract type not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query contract type: %w", err)
+	}
+
+	eligibility := &Eligibility{ContractTypeUUID: contractType.UUID, Reasons: []EligibilityReason{}}
+	for _, problem := range coverageProblems(*contractType, caller.ShopType, Item{Price: input.Price}, input.StartDate, input.EndDate) {
+		eligibility.Reasons = append(eligibility.Reasons, EligibilityReason{Code: problem.Code, Message: problem.Message})
+	}
+	eligibility.Eligible = len(eligibility.Reasons) == 0
+
+	return eligibility, nil
+}
+
+// QuoteRequest describes the contract to be priced.
+type QuoteRequest struct {
+	ContractTypeUUID string    `json:"contract_type_uuid" validate:"required"`
+	Item             Item      `json:"item"`
+	StartDate        time.Time `json:"start_date" validate:"required"`
+	EndDate          time.Time `json:"end_date" validate:"required,after=start_date"`
+}
+
+// quoteContract prices a contract without creating it and returns a signed,
+// expiring quote.
+func quoteContract(store Store, caller *User, dto *QuoteRequest) (*Quote, error) {
+	// Check if the contract type exists
+	contractType, err := store.GetContractType(dto.ContractTypeUUID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, notFoundError("contract_type_not_found", "contract type not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query contract type: %w", err)
+	}
+
+	// Check the item and period against the coverage limits
+	if err := checkCoverage(*contractType, caller.ShopType, dto.Item, dto.StartDate, dto.EndDate); err != nil {
+		return nil, err
+	}
+
+	premium, err := computePremium(*contractType, dto.Item, dto.StartDate, dto.EndDate)
+	if err != nil {
+		return nil, validationError("premium_unavailable", "failed to compute premium: %v", err)
+	}
+
+	quote := &Quote{
+		ContractTypeUUID: dto.ContractTypeUUID,
+		Item:             dto.Item,
+		StartDate:        dto.StartDate,
+		EndDate:          dto.EndDate,
+		Days:             contractDurationDays(dto.StartDate, dto.EndDate),
+		Premium:          premium,
+		ExpiresAt:        time.Now().Add(quoteValidity).UTC().Truncate(time.Second),
+	}
+
+	// Sign the quote
+	quote.Token, err = signPayload("quote", quote)
 	if err != nil {
-		return nil, errors.New("invalid input: " + err.Error())
+		return nil, fmt.Errorf("failed to sign quote: %w", err)
+	}
+
+	return quote, nil
+}
+
+// verifyQuote decodes a quote token and checks that it is still valid and
+// matches the contract being created.
+func verifyQuote(token, contractTypeUUID string, item Item, startDate, endDate time.Time) (*Quote, error) {
+	var quote Quote
+	if err := verifyPayload("quote", token, &quote); err != nil {
+		return nil, validationError("invalid_quote", "invalid quote: %v", err)
+	}
+
+	if time.Now().After(quote.ExpiresAt) {
+		return nil, preconditionFailedError("quote_expired", "quote has expired")
+	}
+	if quote.ContractTypeUUID != contractTypeUUID || quote.Item != item ||
+		!quote.StartDate.Equal(startDate) || !quote.EndDate.Equal(endDate) {
+		return nil, validationError("quote_mismatch", "quote does not match the contract terms")
+	}
+
+	return &quote, nil
+}
+
+// CreateContractRequest describes a contract sold to a customer, who is
+// registered on the way if they do not exist yet.
+type CreateContractRequest struct {
+	ContractTypeUUID string    `json:"contract_type_uuid" validate:"required"`
+	Username         string    `json:"username" validate:"required"`
+	Password         string    `json:"password"`
+	FirstName        string    `json:"first_name"`
+	LastName         string    `json:"last_name"`
+	Item             Item      `json:"item"`
+	StartDate        time.Time `json:"start_date" validate:"required"`
+	EndDate          time.Time `json:"end_date" validate:"required,after=start_date"`
+	Quote            string    `json:"quote"`
+}
+
+// CreateContract creates a contract, ensuring the password is hashed before creating a user.
+func createContract(store Store, caller *User, dto *CreateContractRequest) (*Contract, error) {
+	// Check if the contract type exists
+	contractType, err := store.GetContractType(dto.ContractTypeUUID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, notFoundError("contract_type_not_found", "contract type not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query contract type: %w", err)
+	}
+
+	// Check the item and period against the coverage limits, which may have
+	// changed since a quote was issued
+	if err := checkCoverage(*contractType, caller.ShopType, dto.Item, dto.StartDate, dto.EndDate); err != nil {
+		return nil, err
 	}
 
 	// Check if user exists or create a new one
-	var user User
-	err = db.Where("username = ?", dto.Username).First(&user).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) && dto.Password != "" {
+	user, err := store.GetUser(dto.Username)
+	if errors.Is(err, ErrNotFound) && dto.Password != "" {
 		// Hash the password
 		hashedPassword, err := HashPassword(dto.Password)
 		if err != nil {
-			return nil, errors.New("failed to hash password: " + err.Error())
+			return nil, fmt.Errorf("failed to hash password: %w", err)
 		}
 
 		// Create a new user
-		user = User{
+		user = &User{
 			Username:  dto.Username,
 			Password:  hashedPassword,
 			FirstName: dto.FirstName,
 			LastName:  dto.LastName,
+			Role:      RoleCustomer,
+		}
+		if err := store.CreateUser(user); err != nil {
+			return nil, fmt.Errorf("failed to create user: %w", err)
 		}
-		if err := db.Create(&user).Error; err != nil {
-			return nil, errors.New("failed to create user: " + err.Error())
+		if err := recordAudit(store, caller.Username, "user.create", "user", user.Username, nil, user); err != nil {
+			return nil, err
 		}
+	} else if errors.Is(err, ErrNotFound) {
+		return nil, validationError("password_required", "a password is required to create the customer %s", dto.Username)
 	} else if err != nil {
-		return nil, errors.New("failed to query user: " + err.Error())
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	} else if user.Role != RoleCustomer {
+		return nil, conflictError("not_a_customer", "contracts can only be created for customers")
 	}
 
-	// Check if the contract type exists
-	var contractType ContractType
-	err = db.Where("uuid = ?", dto.ContractTypeUUID).First(&contractType).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, errors.New("contract type not found")
-	} else if err != nil {
-		return nil, errors.New("failed to query contract type: " + err.Error())
+	// Bind to the quote if one was given, otherwise compute the premium from
+	// the contract type's formula
+	var premium float32
+	if dto.Quote != "" {
+		quote, err := verifyQuote(dto.Quote, dto.ContractTypeUUID, dto.Item, dto.StartDate, dto.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		premium = quote.Premium
+	} else {
+		premium, err = computePremium(*contractType, dto.Item, dto.StartDate, dto.EndDate)
+		if err != nil {
+			return nil, validationError("premium_unavailable", "failed to compute premium: %v", err)
+		}
 	}
 
-	// Create the contract
+	// Create the contract under the next policy number
+	number, err := nextNumber(store, policyNumberPrefix, time.Now())
+	if err != nil {
+		return nil, err
+	}
 	contract := &Contract{
-		UUID:             dto.UUID,
+		UUID:             newUUID(),
+		Number:           number,
 		Username:         dto.Username,
 		ContractTypeUUID: dto.ContractTypeUUID,
 		Item:             dto.Item,
 		StartDate:        dto.StartDate,
 		EndDate:          dto.EndDate,
+		Premium:          premium,
 		Void:             false,
-		ClaimIndex:       []string{},
 	}
 
-	if err := db.Create(contract).Error; err != nil {
-		return nil, errors.New("failed to create contract: " + err.Error())
+	if err := store.CreateContract(contract); err != nil {
+		return nil, fmt.Errorf("failed to create contract: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "contract.create", "contract", contract.UUID, nil, contract); err != nil {
+		return nil, err
+	}
+
+	// Mint the contract's token for its owner
+	if _, err := mintContractToken(store, caller, contract, contractType); err != nil {
+		return nil, err
 	}
 
 	return contract, nil
 }
 
-// CreateUser creates a user with a hashed password.
-func createUser(db *gorm.DB, args string) (*User, error) {
-	// Parse the input JSON
-	var user User
-	err := json.Unmarshal([]byte(args), &user)
-	if err != nil {
-		return nil, errors.New("invalid input: " + err.Error())
+// CreateUserRequest defines a new user account. Customers' contracts are
+// sold to them through POST /contracts, not created with the account.
+type CreateUserRequest struct {
+	Username  string `json:"username" validate:"required"`
+	Password  string `json:"password" validate:"required"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Role      Role   `json:"role"`
+	ShopType  string `json:"shop_type"` // Merchants only
+}
+
+// CreateUser creates a user with a hashed password and the requested role.
+func createUser(store Store, caller *User, input *CreateUserRequest) (*User, error) {
+	user := &User{
+		Username:  input.Username,
+		Password:  input.Password,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Role:      input.Role,
+		ShopType:  input.ShopType,
+	}
+
+	// Validate the role, defaulting to customer
+	if user.Role == "" {
+		user.Role = RoleCustomer
+	}
+	if !user.Role.Valid() {
+		return nil, validationError("invalid_role", "invalid role: %s", user.Role)
+	}
+
+	// Only merchants sell contracts, and only of their shop's type
+	if user.Role == RoleMerchant && strings.TrimSpace(user.ShopType) == "" {
+		return nil, fieldErrors(FieldError{Field: "shop_type", Reason: "is required for merchants"})
+	} else if user.Role != RoleMerchant {
+		user.ShopType = ""
 	}
 
 	// Hash the password
 	hashedPassword, err := HashPassword(user.Password)
 	if err != nil {
-		return nil, errors.New("failed to hash password: " + err.Error())
+		return nil, fmt.Errorf("failed to hash password: %w", err)
 	}
 	user.Password = hashedPassword
 
 	// Check if the user already exists
-	var existingUser User
-	err = db.Where("username = ?", user.Username).First(&existingUser).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	existingUser, err := store.GetUser(user.Username)
+	if errors.Is(err, ErrNotFound) {
 		// User does not exist, create a new one
-		if err := db.Create(&user).Error; err != nil {
-			return nil, errors.New("failed to create user: " + err.Error())
+		if err := store.CreateUser(user); err != nil {
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		if err := recordAudit(store, caller.Username, "user.create", "user", user.Username, nil, user); err != nil {
+			return nil, err
 		}
-		return &user, nil
+		user.Password = ""
+		return user, nil
 	} else if err != nil {
-		return nil, errors.New("failed to query user: " + err.Error())
+		return nil, fmt.Errorf("failed to query user: %w", err)
 	}
 
 	// User already exists, return the existing user
-	return &existingUser, nil
+	existingUser.Password = ""
+	return existingUser, nil
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCoverageRules(t *testing.T) {
+	merchant := &User{Username: "merchant", Role: RoleMerchant, ShopType: "phones"}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type sale struct {
+		contractType ContractType
+		shopType     string
+		price        float32
+		days         int
+	}
+	tests := []struct {
+		name      string
+		modify    func(s *sale)
+		wantCodes []string
+	}{
+		{name: "eligible", modify: func(s *sale) {}},
+		{name: "shop type ignores case", modify: func(s *sale) { s.shopType = "Phones" }},
+		{name: "inactive contract type", modify: func(s *sale) { s.contractType.Active = false },
+			wantCodes: []string{"contract_type_inactive"}},
+		{name: "other shop type", modify: func(s *sale) { s.shopType = "bikes" },
+			wantCodes: []string{"shop_type_mismatch"}},
+		{name: "no shop type", modify: func(s *sale) { s.shopType = "" },
+			wantCodes: []string{"shop_type_mismatch"}},
+		{name: "empty period", modify: func(s *sale) { s.days = 0 },
+			wantCodes: []string{"invalid_period"}},
+		{name: "minimum duration", modify: func(s *sale) { s.days = 30 }},
+		{name: "below minimum duration", modify: func(s *sale) { s.days = 29 },
+			wantCodes: []string{"duration_out_of_range"}},
+		{name: "maximum duration", modify: func(s *sale) { s.days = 365 }},
+		{name: "above maximum duration", modify: func(s *sale) { s.days = 366 },
+			wantCodes: []string{"duration_out_of_range"}},
+		{name: "no maximum duration", modify: func(s *sale) {
+			s.contractType.MaxDurationDays = 0
+			s.days = 1000
+		}},
+		{name: "price at maximum sum insured", modify: func(s *sale) { s.price = 1000 }},
+		{name: "price above maximum sum insured", modify: func(s *sale) { s.price = 1000.01 },
+			wantCodes: []string{"sum_insured_exceeded"}},
+		{name: "no maximum sum insured", modify: func(s *sale) {
+			s.contractType.MaxSumInsured = 0
+			s.price = 5000
+		}},
+		{name: "every problem reported", modify: func(s *sale) {
+			s.contractType.Active = false
+			s.shopType = "bikes"
+			s.days = 29
+			s.price = 2000
+		}, wantCodes: []string{"contract_type_inactive", "shop_type_mismatch", "duration_out_of_range", "sum_insured_exceeded"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := sale{
+				contractType: ContractType{
+					UUID:            "type-1",
+					ShopType:        "phones",
+					FormulaPerDay:   "price * 0.001",
+					MaxSumInsured:   1000,
+					Active:          true,
+					MinDurationDays: 30,
+					MaxDurationDays: 365,
+				},
+				shopType: "phones",
+				price:    500,
+				days:     90,
+			}
+			tt.modify(&s)
+			store := newMemoryStore()
+			if err := store.CreateContractType(&s.contractType); err != nil {
+				t.Fatal(err)
+			}
+			caller := *merchant
+			caller.ShopType = s.shopType
+			end := start.AddDate(0, 0, s.days)
+
+			eligibility, err := checkEligibility(store, &caller, &EligibilityRequest{UUID: "type-1", Price: s.price, StartDate: start, EndDate: end})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var codes []string
+			for _, reason := range eligibility.Reasons {
+				codes = append(codes, reason.Code)
+			}
+			if !slices.Equal(codes, tt.wantCodes) || eligibility.Eligible != (len(tt.wantCodes) == 0) {
+				t.Errorf("eligible %v, reasons %v, want %v", eligibility.Eligible, codes, tt.wantCodes)
+			}
+
+			// Creating the contract fails with the first reason
+			_, err = createContract(store, &caller, &CreateContractRequest{
+				ContractTypeUUID: "type-1",
+				Username:         "alice",
+				Password:         "secret",
+				Item:             Item{ID: 1, Brand: "Acme", Price: s.price},
+				StartDate:        start,
+				EndDate:          end,
+			})
+			if len(tt.wantCodes) == 0 {
+				if err != nil {
+					t.Errorf("createContract: %v", err)
+				}
+				return
+			}
+			if code := errorCode(err); code != tt.wantCodes[0] {
+				t.Errorf("createContract error = %v, want %s", err, tt.wantCodes[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+)
+
+// signingKey is the HMAC key used for quotes and other server-issued tokens.
+// It is set from the signing_key setting at startup; without it a random key
+// is generated, so tokens do not survive a restart.
+var signingKey []byte
+
+func loadSigningKey(configured string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+	log.Println("signing_key not set, using a random signing key")
+	return key
+}
+
+// signPayload encodes payload as JSON and returns it together with its HMAC
+// as "<payload>.<signature>", both base64url encoded. The purpose is mixed
+// into the signature so that a token issued for one use cannot be replayed
+// as another.
+func signPayload(purpose string, payload interface{}) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(computeSignature(purpose, encoded)), nil
+}
+
+// verifyPayload checks the token's signature for the given purpose and
+// decodes its payload into out.
+func verifyPayload(purpose, token string, out interface{}) error {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return errors.New("malformed token")
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("malformed token signature")
+	}
+	if !hmac.Equal(mac, computeSignature(purpose, encoded)) {
+		return errors.New("invalid token signature")
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.New("malformed token payload")
+	}
+	return json.Unmarshal(body, out)
+}
+
+func computeSignature(purpose, encoded string) []byte {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(purpose + "." + encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Store lookups that match no record.
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when creating a record whose key already exists.
+var ErrDuplicate = errors.New("record already exists")
+
+// ErrMissingReference is returned when creating a record that belongs to a
+// record that does not exist, such as a claim on an unknown contract.
+var ErrMissingReference = errors.New("referenced record not found")
+
+// ErrVersionConflict is returned when saving a versioned record that was
+// changed since it was loaded. Unlike the other store errors it is also a
+// domain error, so that it reaches clients as 412 wherever it surfaces.
+var ErrVersionConflict = preconditionFailedError("version_conflict", "the record was changed concurrently, fetch it again and retry")
+
+// Store is the persistence layer used by every handler. The GORM
+// implementation backs production; the in-memory one lets the service run
+// without a database for local development and tests.
+//
+// Contract types, contracts, claims, repair orders and transfers are
+// versioned: Create sets their Version to 1, and Save only succeeds if the
+// record still has the Version it was loaded with, which it then increments.
+// Otherwise Save returns ErrVersionConflict.
+//
+// The Get...ForUpdate lookups also lock the record until the transaction
+// they run in ends, so that concurrent operations on it are serialized.
+// Outside a transaction they behave like the plain lookups.
+//
+// Records are stored without their associations, which Create and Save
+// ignore. ListContracts loads each contract's Claims; other lookups leave
+// associations empty.
+type Store interface {
+	// Transaction runs fn as one unit of work: everything fn does through tx
+	// is committed if it returns nil and rolled back if it returns an error
+	// or panics.
+	Transaction(fn func(tx Store) error) error
+
+	// Users
+	GetUser(username string) (*User, error)
+	CreateUser(user *User) error
+	SaveUser(user *User) error
+
+	// Contract types
+	GetContractType(uuid string) (*ContractType, error)
+	ListContractTypes(filter ContractTypeFilter) ([]ContractType, error)
+	CreateContractType(contractType *ContractType) error
+	SaveContractType(contractType *ContractType) error
+
+	// Contracts
+	GetContract(uuid string) (*Contract, error)
+	GetContractForUpdate(uuid string) (*Contract, error)
+	ListContracts(filter ContractFilter) ([]Contract, error)
+	CreateContract(contract *Contract) error
+	SaveContract(contract *Contract) error
+
+	// Claims
+	GetClaim(uuid string) (*Claim, error)
+	GetClaimForUpdate(uuid string) (*Claim, error)
+	ListClaims(filter ClaimFilter) ([]Claim, error)
+	CreateClaim(claim *Claim) error
+	SaveClaim(claim *Claim) error
+
+	// Repair orders
+	GetRepairOrder(uuid string) (*RepairOrder, error)
+	GetRepairOrderByClaim(claimUUID string) (*RepairOrder, error)
+	GetRepairOrderByClaimForUpdate(claimUUID string) (*RepairOrder, error)
+	ListRepairOrders(filter RepairOrderFilter) ([]RepairOrder, error)
+	CreateRepairOrder(repairOrder *RepairOrder) error
+	SaveRepairOrder(repairOrder *RepairOrder) error
+
+	// Audit log
+	LastAuditEntry() (*AuditEntry, error)
+	AppendAuditEntry(entry *AuditEntry) error
+	ListAuditEntries(filter AuditFilter) ([]AuditEntry, error)
+	WalkAuditEntries(fn func(entry *AuditEntry) error) error
+
+	// Contract tokens
+	GetToken(tokenID string) (*ContractToken, error)
+	GetTokenByContract(contractUUID string) (*ContractToken, error)
+	ListTokens(filter TokenFilter) ([]ContractToken, error)
+	CreateToken(token *ContractToken) error
+	SaveToken(token *ContractToken) error
+
+	// Contract transfers
+	GetTransfer(uuid string) (*ContractTransfer, error)
+	GetTransferForUpdate(uuid string) (*ContractTransfer, error)
+	ListTransfers(filter TransferFilter) ([]ContractTransfer, error)
+	CreateTransfer(transfer *ContractTransfer) error
+	SaveTransfer(transfer *ContractTransfer) error
+
+	// Idempotency keys
+	GetIdempotencyRecord(username, key string) (*IdempotencyRecord, error)
+	CreateIdempotencyRecord(record *IdempotencyRecord) error
+	SaveIdempotencyRecord(record *IdempotencyRecord) error
+	DeleteIdempotencyRecord(username, key string) error
+	DeleteExpiredIdempotencyRecords(now time.Time) error
+
+	// Record numbers
+	NextNumber(prefix string, year int) (int64, error)
+}
+
+// ListOptions orders and pages a list. Items are ordered by the Sort
+// column, ties broken by primary key in the same direction, so the order is
+// stable and After can resume it. The zero value lists everything in the
+// list's default order.
+type ListOptions struct {
+	// Sort is a column of the list's listSpec, the default order if empty
+	Sort       string
+	Descending bool
+	// Limit caps the number of items returned, unlimited if zero
+	Limit int
+	// After skips the items up to and including the cursor position
+	After *ListCursor
+	// Total, if set, receives the number of items matching the filter
+	// regardless of Limit and After
+	Total *int64
+}
+
+// ListCursor is a position in a list: the sort column and primary key
+// values of an item.
+type ListCursor struct {
+	Value interface{}
+	Key   interface{}
+}
+
+// listSpec describes how a list of T can be ordered. Columns maps every
+// sortable column, including the primary key, to its value in an item, which
+// is a string, int64, float64, bool or time.Time.
+type listSpec[T any] struct {
+	Key         string
+	DefaultSort string
+	Columns     map[string]func(*T) interface{}
+}
+
+var contractTypeList = listSpec[ContractType]{
+	Key:         "uuid",
+	DefaultSort: "uuid",
+	Columns: map[string]func(*ContractType) interface{}{
+		"uuid":            func(ct *ContractType) interface{} { return ct.UUID },
+		"shop_type":       func(ct *ContractType) interface{} { return ct.ShopType },
+		"max_sum_insured": func(ct *ContractType) interface{} { return float64(ct.MaxSumInsured) },
+	},
+}
+
+var contractList = listSpec[Contract]{
+	Key:         "uuid",
+	DefaultSort: "uuid",
+	Columns: map[string]func(*Contract) interface{}{
+		"uuid":       func(c *Contract) interface{} { return c.UUID },
+		"number":     func(c *Contract) interface{} { return c.Number },
+		"username":   func(c *Contract) interface{} { return c.Username },
+		"start_date": func(c *Contract) interface{} { return c.StartDate },
+		"end_date":   func(c *Contract) interface{} { return c.EndDate },
+		"premium":    func(c *Contract) interface{} { return float64(c.Premium) },
+	},
+}
+
+var claimList = listSpec[Claim]{
+	Key:         "uuid",
+	DefaultSort: "uuid",
+	Columns: map[string]func(*Claim) interface{}{
+		"uuid":          func(c *Claim) interface{} { return c.UUID },
+		"number":        func(c *Claim) interface{} { return c.Number },
+		"contract_uuid": func(c *Claim) interface{} { return c.ContractUUID },
+		"date":          func(c *Claim) interface{} { return c.Date },
+		"status":        func(c *Claim) interface{} { return int64(c.Status) },
+	},
+}
+
+var repairOrderList = listSpec[RepairOrder]{
+	Key:         "uuid",
+	DefaultSort: "claim_uuid",
+	Columns: map[string]func(*RepairOrder) interface{}{
+		"uuid":          func(r *RepairOrder) interface{} { return r.UUID },
+		"claim_uuid":    func(r *RepairOrder) interface{} { return r.ClaimUUID },
+		"contract_uuid": func(r *RepairOrder) interface{} { return r.ContractUUID },
+	},
+}
+
+var auditEntryList = listSpec[AuditEntry]{
+	Key:         "id",
+	DefaultSort: "id",
+	Columns: map[string]func(*AuditEntry) interface{}{
+		"id":        func(e *AuditEntry) interface{} { return int64(e.ID) },
+		"timestamp": func(e *AuditEntry) interface{} { return e.Timestamp },
+	},
+}
+
+var tokenList = listSpec[ContractToken]{
+	Key:         "token_id",
+	DefaultSort: "minted_at",
+	Columns: map[string]func(*ContractToken) interface{}{
+		"token_id":  func(t *ContractToken) interface{} { return t.TokenID },
+		"minted_at": func(t *ContractToken) interface{} { return t.MintedAt },
+	},
+}
+
+var transferList = listSpec[ContractTransfer]{
+	Key:         "uuid",
+	DefaultSort: "initiated_at",
+	Columns: map[string]func(*ContractTransfer) interface{}{
+		"uuid":         func(t *ContractTransfer) interface{} { return t.UUID },
+		"initiated_at": func(t *ContractTransfer) interface{} { return t.InitiatedAt },
+	},
+}
+
+// compareValues orders two column values of the same type.
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case a:
+			return 1
+		default:
+			return -1
+		}
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		panic(fmt.Sprintf("unsupported sort value %T", a))
+	}
+}
+
+// ContractTypeFilter restricts ListContractTypes. ShopType matches
+// case-insensitively anywhere in the contract type's shop type, % and _
+// matching only themselves.
+type ContractTypeFilter struct {
+	Active   *bool
+	ShopType string
+	ListOptions
+}
+
+// ContractFilter restricts ListContracts. Start dates match from StartFrom
+// inclusive to StartTo exclusive; zero times leave the range open.
+type ContractFilter struct {
+	Number           string
+	Username         string
+	ContractTypeUUID string
+	Void             *bool
+	StartFrom        time.Time
+	StartTo          time.Time
+	ListOptions
+}
+
+// ClaimFilter restricts ListClaims. Zero values match everything. Claim
+// dates match from DateFrom inclusive to DateTo exclusive.
+type ClaimFilter struct {
+	Number       string
+	ContractUUID string
+	Status       ClaimStatus
+	IsTheft      *bool
+	DateFrom     time.Time
+	DateTo       time.Time
+	ListOptions
+}
+
+// RepairOrderFilter restricts ListRepairOrders.
+type RepairOrderFilter struct {
+	Ready        *bool
+	ContractUUID string
+	ListOptions
+}
+
+// AuditFilter restricts ListAuditEntries.
+type AuditFilter struct {
+	EntityType string
+	EntityID   string
+	ListOptions
+}
+
+// TokenFilter restricts ListTokens.
+type TokenFilter struct {
+	Owner string
+	ListOptions
+}
+
+// TransferFilter restricts ListTransfers. Username matches either side of
+// the transfer.
+type TransferFilter struct {
+	ContractUUID string
+	Username     string
+	Status       TransferStatus
+	ListOptions
+}
+
+// inRange reports whether t lies in [from, to), zero bounds being open.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// openStore creates the store selected by the configuration.
+func openStore(config *Config) (Store, error) {
+	switch config.Store {
+	case "postgres":
+		db, err := connectDatabase(config.postgresDSN())
+		if err != nil {
+			return nil, err
+		}
+		if err := prepareDatabase(db, config.AutoMigrate); err != nil {
+			return nil, err
+		}
+		return newGormStore(db), nil
+	case "memory":
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q, expected postgres or memory", config.Store)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLikeEscaper(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "phones", want: "phones"},
+		{in: "%", want: `\%`},
+		{in: "e_bikes", want: `e\_bikes`},
+		{in: `a\b`, want: `a\\b`},
+		{in: `100%_\`, want: `100\%\_\\`},
+	}
+
+	for _, tt := range tests {
+		if got := likeEscaper.Replace(tt.in); got != tt.want {
+			t.Errorf("likeEscaper.Replace(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestListContractTypesShopType(t *testing.T) {
+	store := newMemoryStore()
+	for _, shopType := range []string{"Phones", "e_bikes", "ebikes", "100% cotton"} {
+		if err := store.CreateContractType(&ContractType{UUID: shopType, ShopType: shopType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		shopType string
+		want     []string
+	}{
+		{shopType: "", want: []string{"100% cotton", "Phones", "e_bikes", "ebikes"}},
+		{shopType: "PHONE", want: []string{"Phones"}},
+		{shopType: "bikes", want: []string{"e_bikes", "ebikes"}},
+		{shopType: "_", want: []string{"e_bikes"}},
+		{shopType: "%", want: []string{"100% cotton"}},
+		{shopType: "e%s", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shopType, func(t *testing.T) {
+			contractTypes, err := store.ListContractTypes(ContractTypeFilter{ShopType: tt.shopType, ListOptions: ListOptions{Sort: "uuid", Limit: 10}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ct := range contractTypes {
+				got = append(got, ct.ShopType)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("shop type %q matched %v, want %v", tt.shopType, got, tt.want)
+			}
+		})
+	}
+}
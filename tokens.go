@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ContractToken is the non-fungible token minted for a contract. TokenID is
+// derived from the contract UUID, and ContentHash commits to the insured item
+// and coverage terms as they were when the token was minted.
+type ContractToken struct {
+	TokenID      string    `gorm:"primaryKey" json:"token_id"`
+	ContractUUID string    `gorm:"uniqueIndex" json:"contract_uuid"`
+	Owner        string    `json:"owner"`
+	MintedAt     time.Time `json:"minted_at"`
+	ContentHash  string    `json:"content_hash"`
+}
+
+// TokenVerification compares a token against the current state of its
+// contract.
+type TokenVerification struct {
+	TokenID      string `json:"token_id"`
+	ContractUUID string `json:"contract_uuid"`
+	Valid        bool   `json:"valid"`
+	HashMatches  bool   `json:"hash_matches"`
+	OwnerMatches bool   `json:"owner_matches"`
+	StoredHash   string `json:"stored_hash"`
+	CurrentHash  string `json:"current_hash"`
+}
+
+// tokenIDForContract derives the stable 256-bit token ID of a contract.
+func tokenIDForContract(contractUUID string) string {
+	sum := sha256.Sum256([]byte("nft:contract:" + contractUUID))
+	return hex.EncodeToString(sum[:])
+}
+
+// contractContentHash hashes the item and coverage terms of a contract.
+// Dates are normalized to UTC microseconds so that the hash survives a round
+// trip through the database.
+func contractContentHash(contract *Contract, contractType *ContractType) (string, error) {
+	content, err := json.Marshal(struct {
+		ContractUUID     string  `json:"contract_uuid"`
+		ContractTypeUUID string  `json:"contract_type_uuid"`
+		Item             Item    `json:"item"`
+		StartDate        string  `json:"start_date"`
+		EndDate          string  `json:"end_date"`
+		Premium          float32 `json:"premium"`
+		MaxSumInsured    float32 `json:"max_sum_insured"`
+		TheftInsured     bool    `json:"theft_insured"`
+		Description      string  `json:"description"`
+		Conditions       string  `json:"conditions"`
+	}{
+		ContractUUID:     contract.UUID,
+		ContractTypeUUID: contract.ContractTypeUUID,
+		Item:             contract.Item,
+		StartDate:        contract.StartDate.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		EndDate:          contract.EndDate.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		Premium:          contract.Premium,
+		MaxSumInsured:    contractType.MaxSumInsured,
+		TheftInsured:     contractType.TheftInsured,
+		Description:      contractType.Description,
+		Conditions:       contractType.Conditions,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// mintContractToken mints the token for a newly created contract, owned by
+// the contract's user.
+func mintContractToken(store Store, caller *User, contract *Contract, contractType *ContractType) (*ContractToken, error) {
+	contentHash, err := contractContentHash(contract, contractType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash contract: %w", err)
+	}
+
+	token := &ContractToken{
+		TokenID:      tokenIDForContract(contract.UUID),
+		ContractUUID: contract.UUID,
+		Owner:        contract.Username,
+		MintedAt:     time.Now().UTC().Truncate(time.Microsecond),
+		ContentHash:  contentHash,
+	}
+	if err := store.CreateToken(token); err != nil {
+		return nil, fmt.Errorf("failed to mint token: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "token.mint", "token", token.TokenID, nil, token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// findToken looks a token up by token ID or, failing that, by contract UUID.
+func findToken(store Store, tokenID, contractUUID string) (*ContractToken, error) {
+	var token *ContractToken
+	var err error
+	if tokenID != "" {
+		token, err = store.GetToken(tokenID)
+	} else {
+		token, err = store.GetTokenByContract(contractUUID)
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("token_not_found", "token not found")
+		}
+		return nil, fmt.Errorf("failed to fetch token: %w", err)
+	}
+	return token, nil
+}
+
+// TokenRequest identifies a token by its ID or by its contract.
+type TokenRequest struct {
+	TokenID      string `json:"token_id"`
+	ContractUUID string `json:"contract_uuid"`
+}
+
+// Validate requires one of the two identifiers.
+func (r *TokenRequest) Validate() error {
+	if r.TokenID != "" || r.ContractUUID != "" {
+		return nil
+	}
+	return fieldErrors(FieldError{Field: "token_id", Reason: "is required without contract_uuid"})
+}
+
+func getToken(store Store, caller *User, input *TokenRequest) (*ContractToken, error) {
+	return findToken(store, input.TokenID, input.ContractUUID)
+}
+
+func verifyToken(store Store, caller *User, input *TokenRequest) (*TokenVerification, error) {
+	token, err := findToken(store, input.TokenID, input.ContractUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch the contract and its type as they are now
+	contract, err := store.GetContract(token.ContractUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract for token %s: %w", token.TokenID, err)
+	}
+	contractType, err := store.GetContractType(contract.ContractTypeUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract type for token %s: %w", token.TokenID, err)
+	}
+
+	currentHash, err := contractContentHash(contract, contractType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash contract: %w", err)
+	}
+
+	verification := &TokenVerification{
+		TokenID:      token.TokenID,
+		ContractUUID: token.ContractUUID,
+		HashMatches:  currentHash == token.ContentHash && token.TokenID == tokenIDForContract(contract.UUID),
+		OwnerMatches: token.Owner == contract.Username,
+		StoredHash:   token.ContentHash,
+		CurrentHash:  currentHash,
+	}
+	verification.Valid = verification.HashMatches && verification.OwnerMatches
+
+	return verification, nil
+}
+
+// ListTokensRequest selects and pages whose tokens to list.
+type ListTokensRequest struct {
+	PageRequest
+	Owner string `json:"owner"`
+}
+
+func listTokens(store Store, caller *User, input *ListTokensRequest) (*Page[ContractToken], error) {
+	// Customers list their own tokens
+	if input.Owner == "" || caller.Role == RoleCustomer {
+		input.Owner = caller.Username
+	}
+
+	filter := TokenFilter{Owner: input.Owner}
+	tokens, err := listPage(input.PageRequest, tokenList, func(opts ListOptions) ([]ContractToken, error) {
+		filter.ListOptions = opts
+		return store.ListTokens(filter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tokens: %w", err)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TransferStatus tracks a contract transfer from initiation to resolution.
+type TransferStatus string
+
+const (
+	TransferStatusPending   TransferStatus = "pending"
+	TransferStatusAccepted  TransferStatus = "accepted"
+	TransferStatusDeclined  TransferStatus = "declined"
+	TransferStatusCancelled TransferStatus = "cancelled"
+)
+
+// ContractTransfer moves a contract, and its token, from the current owner
+// to a recipient once the recipient accepts.
+type ContractTransfer struct {
+	UUID         string         `gorm:"primaryKey" json:"uuid"`
+	ContractUUID string         `gorm:"index" json:"contract_uuid"`
+	FromUsername string         `json:"from_username"`
+	ToUsername   string         `json:"to_username"`
+	Status       TransferStatus `json:"status"`
+	InitiatedAt  time.Time      `json:"initiated_at"`
+	ResolvedAt   *time.Time     `json:"resolved_at,omitempty"`
+	Version      int64          `gorm:"not null;default:1" json:"version"`
+}
+
+// ProvenanceEvent is one entry in a contract's ownership history.
+type ProvenanceEvent struct {
+	Event        string    `json:"event"`
+	From         string    `json:"from,omitempty"`
+	To           string    `json:"to"`
+	At           time.Time `json:"at"`
+	TransferUUID string    `json:"transfer_uuid,omitempty"`
+}
+
+// checkContractTransferable verifies that a contract may currently change
+// hands: it must not be void, its type must allow transfers and it must have
+// no open claims.
+func checkContractTransferable(store Store, contract *Contract) error {
+	if contract.Void {
+		return conflictError("contract_void", "void contracts cannot be transferred")
+	}
+
+	contractType, err := store.GetContractType(contract.ContractTypeUUID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch contract type: %w", err)
+	}
+	if !contractType.IsTransferable() {
+		return conflictError("contract_not_transferable", "contracts of this type are not transferable")
+	}
+
+	claims, err := store.ListClaims(ClaimFilter{ContractUUID: contract.UUID})
+	if err != nil {
+		return fmt.Errorf("failed to fetch claims: %w", err)
+	}
+	for _, claim := range claims {
+		if claim.Status.IsOpen() {
+			return conflictError("contract_has_open_claim", "contract has an open claim: %s", claim.UUID)
+		}
+	}
+
+	return nil
+}
+
+// InitiateTransferRequest offers a contract to another customer.
+type InitiateTransferRequest struct {
+	ContractUUID string `json:"contract_uuid" validate:"required"`
+	ToUsername   string `json:"to_username" validate:"required"`
+}
+
+func initiateContractTransfer(store Store, caller *User, input *InitiateTransferRequest) (*ContractTransfer, error) {
+	// Only the current owner can initiate a transfer. The contract stays
+	// locked so that no other transfer of it can start concurrently.
+	contract, err := store.GetContractForUpdate(input.ContractUUID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to fetch contract: %w", err)
+	}
+	if err != nil || contract.Username != caller.Username {
+		return nil, notFoundError("contract_not_found", "contract not found: %s", input.ContractUUID)
+	}
+	if err := checkContractTransferable(store, contract); err != nil {
+		return nil, err
+	}
+
+	// The recipient must be another customer
+	if input.ToUsername == caller.Username {
+		return nil, validationError("transfer_to_owner", "cannot transfer a contract to its current owner")
+	}
+	recipient, err := store.GetUser(input.ToUsername)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("recipient_not_found", "recipient not found: %s", input.ToUsername)
+		}
+		return nil, fmt.Errorf("failed to fetch recipient: %w", err)
+	}
+	if recipient.Role != RoleCustomer {
+		return nil, validationError("recipient_not_customer", "contracts can only be transferred to customers")
+	}
+
+	// Only one transfer may be pending at a time
+	pending, err := store.ListTransfers(TransferFilter{ContractUUID: contract.UUID, Status: TransferStatusPending})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check pending transfers: %w", err)
+	}
+	if len(pending) > 0 {
+		return nil, conflictError("transfer_pending", "contract already has a pending transfer")
+	}
+
+	transfer := &ContractTransfer{
+		UUID:         newUUID(),
+		ContractUUID: contract.UUID,
+		FromUsername: caller.Username,
+		ToUsername:   recipient.Username,
+		Status:       TransferStatusPending,
+		InitiatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := store.CreateTransfer(transfer); err != nil {
+		return nil, fmt.Errorf("failed to create transfer: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "transfer.initiate", "transfer", transfer.UUID, nil, transfer); err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// findPendingTransfer fetches and locks a transfer that has not been
+// resolved yet.
+func findPendingTransfer(store Store, uuid string) (*ContractTransfer, error) {
+	transfer, err := store.GetTransferForUpdate(uuid)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("transfer_not_found", "transfer not found: %s", uuid)
+		}
+		return nil, fmt.Errorf("failed to fetch transfer: %w", err)
+	}
+	if transfer.Status != TransferStatusPending {
+		return nil, conflictError("transfer_resolved", "transfer is already %s", transfer.Status)
+	}
+	return transfer, nil
+}
+
+// TransferRequest identifies a transfer.
+type TransferRequest struct {
+	UUID string `json:"uuid" validate:"required"`
+}
+
+// getContractTransfer returns one transfer the caller is a party to, with
+// its version as ETag.
+func getContractTransfer(store Store, caller *User, input *TransferRequest) (*ContractTransfer, error) {
+	transfer, err := store.GetTransfer(input.UUID)
+	if err == nil && caller.Username != transfer.FromUsername && caller.Username != transfer.ToUsername {
+		err = ErrNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("transfer_not_found", "transfer not found: %s", input.UUID)
+		}
+		return nil, fmt.Errorf("failed to fetch transfer: %w", err)
+	}
+	return transfer, nil
+}
+
+// ResolveTransferRequest accepts, declines or cancels a transfer.
+type ResolveTransferRequest struct {
+	IfMatch
+	UUID string `json:"uuid" validate:"required"`
+}
+
+func acceptContractTransfer(store Store, caller *User, input *ResolveTransferRequest) (*ContractTransfer, error) {
+	transfer, err := findPendingTransfer(store, input.UUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := input.check(transfer.Version); err != nil {
+		return nil, err
+	}
+	if transfer.ToUsername != caller.Username {
+		return nil, forbiddenError("not_transfer_recipient", "only the recipient can accept a transfer")
+	}
+
+	// Re-check the contract, which may have changed since initiation
+	contract, err := store.GetContractForUpdate(transfer.ContractUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract: %w", err)
+	}
+	if contract.Username != transfer.FromUsername {
+		return nil, preconditionFailedError("contract_owner_changed", "contract owner has changed since the transfer was initiated")
+	}
+	if err := checkContractTransferable(store, contract); err != nil {
+		return nil, err
+	}
+
+	// Move the contract to the recipient
+	beforeContract := *contract
+	contract.Username = caller.Username
+	if err := store.SaveContract(contract); err != nil {
+		return nil, fmt.Errorf("failed to update contract: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "contract.transfer", "contract", contract.UUID, beforeContract, contract); err != nil {
+		return nil, err
+	}
+
+	// Move the token along with it
+	token, err := findToken(store, "", contract.UUID)
+	if err != nil {
+		return nil, err
+	}
+	beforeToken := *token
+	token.Owner = caller.Username
+	if err := store.SaveToken(token); err != nil {
+		return nil, fmt.Errorf("failed to update token: %w", err)
+	}
+	if err := recordAudit(store, caller.Username, "token.transfer", "token", token.TokenID, beforeToken, token); err != nil {
+		return nil, err
+	}
+
+	if err := resolveContractTransfer(store, caller, transfer, TransferStatusAccepted); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func cancelContractTransfer(store Store, caller *User, input *ResolveTransferRequest) (*ContractTransfer, error) {
+	transfer, err := findPendingTransfer(store, input.UUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := input.check(transfer.Version); err != nil {
+		return nil, err
+	}
+
+	// The owner cancels, the recipient declines
+	var status TransferStatus
+	switch caller.Username {
+	case transfer.FromUsername:
+		status = TransferStatusCancelled
+	case transfer.ToUsername:
+		status = TransferStatusDeclined
+	default:
+		return nil, forbiddenError("not_transfer_party", "only the owner or the recipient can cancel a transfer")
+	}
+	if err := resolveContractTransfer(store, caller, transfer, status); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func resolveContractTransfer(store Store, caller *User, transfer *ContractTransfer, status TransferStatus) error {
+	before := *transfer
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	transfer.Status = status
+	transfer.ResolvedAt = &now
+	if err := store.SaveTransfer(transfer); err != nil {
+		return fmt.Errorf("failed to update transfer: %w", err)
+	}
+
+	return recordAudit(store, caller.Username, "transfer."+string(status), "transfer", transfer.UUID, before, transfer)
+}
+
+// ListTransfersRequest filters and pages the caller's transfers.
+type ListTransfersRequest struct {
+	PageRequest
+	Status       TransferStatus `json:"status"`
+	ContractUUID string         `json:"contract_uuid"`
+}
+
+func listContractTransfers(store Store, caller *User, input *ListTransfersRequest) (*Page[ContractTransfer], error) {
+	// Transfers the caller sent or received
+	filter := TransferFilter{Username: caller.Username, ContractUUID: input.ContractUUID, Status: input.Status}
+	transfers, err := listPage(input.PageRequest, transferList, func(opts ListOptions) ([]ContractTransfer, error) {
+		filter.ListOptions = opts
+		return store.ListTransfers(filter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transfers: %w", err)
+	}
+
+	return transfers, nil
+}
+
+// ContractProvenanceRequest identifies the contract whose history to show.
+type ContractProvenanceRequest struct {
+	ContractUUID string `json:"contract_uuid" validate:"required"`
+}
+
+func getContractProvenance(store Store, caller *User, input *ContractProvenanceRequest) ([]ProvenanceEvent, error) {
+	contract, err := store.GetContract(input.ContractUUID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, notFoundError("contract_not_found", "contract not found: %s", input.ContractUUID)
+		}
+		return nil, fmt.Errorf("failed to fetch contract: %w", err)
+	}
+
+	// Accepted transfers make up the ownership history
+	transfers, err := store.ListTransfers(TransferFilter{ContractUUID: contract.UUID, Status: TransferStatusAccepted})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transfers: %w", err)
+	}
+
+	// Customers may only see the history of contracts they own or owned
+	if caller.Role == RoleCustomer && contract.Username != caller.Username {
+		involved := false
+		for _, transfer := range transfers {
+			involved = involved || transfer.FromUsername == caller.Username
+		}
+		if !involved {
+			return nil, notFoundError("contract_not_found", "contract not found: %s", input.ContractUUID)
+		}
+	}
+
+	// The history starts with the mint to the first owner
+	token, err := findToken(store, "", contract.UUID)
+	if err != nil {
+		return nil, err
+	}
+	firstOwner := contract.Username
+	if len(transfers) > 0 {
+		sort.Slice(transfers, func(i, j int) bool { return transfers[i].ResolvedAt.Before(*transfers[j].ResolvedAt) })
+		firstOwner = transfers[0].FromUsername
+	}
+	events := []ProvenanceEvent{{Event: "mint", To: firstOwner, At: token.MintedAt}}
+
+	for _, transfer := range transfers {
+		events = append(events, ProvenanceEvent{
+			Event:        "transfer",
+			From:         transfer.FromUsername,
+			To:           transfer.ToUsername,
+			At:           *transfer.ResolvedAt,
+			TransferUUID: transfer.UUID,
+		})
+	}
+
+	return events, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requests are validated after decoding from their `validate` struct tags,
+// a comma-separated list of rules:
+//
+//	required    the field is not empty: a non-blank string, a non-zero
+//	            number or time, a non-nil pointer or a non-empty slice
+//	min=N       numbers are at least N, strings and slices have at least N
+//	            characters or items
+//	max=N       numbers are at most N, strings and slices have at most N
+//	            characters or items
+//	oneof=a b   the string value is one of the space-separated values
+//	after=name  the time is after the field with JSON name name, checked
+//	            only when both are set
+//
+// Rules other than required are skipped for empty fields. Nested structs are
+// validated as well, their fields reported as "parent.child".
+
+// FieldError reports why one request field is invalid. Field is the JSON
+// path of the field, such as "item.price".
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// validationRule is one parsed rule of a validate tag.
+type validationRule struct {
+	name string
+	arg  string
+}
+
+var validationRules = map[string]bool{"required": true, "min": true, "max": true, "oneof": true, "after": true}
+
+// parseValidationRules parses a validate tag.
+func parseValidationRules(tag string) ([]validationRule, error) {
+	var rules []validationRule
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		if !validationRules[name] {
+			return nil, fmt.Errorf("unknown validation rule %q", name)
+		}
+		if (name == "min" || name == "max") && arg != "" {
+			if _, err := strconv.ParseFloat(arg, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s bound %q", name, arg)
+			}
+		}
+		if name != "required" && arg == "" {
+			return nil, fmt.Errorf("validation rule %q needs an argument", name)
+		}
+		rules = append(rules, validationRule{name: name, arg: arg})
+	}
+	return rules, nil
+}
+
+// checkValidationRules makes sure at startup that every validate tag
+// reachable from t parses and that after= names a sibling time field.
+func checkValidationRules(t reflect.Type) error {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return nil
+	}
+
+	siblings := requestFields(t)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		rules, err := parseValidationRules(field.Tag.Get("validate"))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+		}
+		for _, rule := range rules {
+			if rule.name == "after" && siblings[rule.arg] != reflect.TypeOf(time.Time{}) {
+				return fmt.Errorf("%s.%s: after=%s does not name a time field", t.Name(), field.Name, rule.arg)
+			}
+		}
+		if err := checkValidationRules(field.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRequest checks v, a pointer to a struct, against its validate
+// tags and reports every invalid field at once.
+func validateRequest(v interface{}) error {
+	var problems []FieldError
+	validateStruct(reflect.ValueOf(v), "", &problems)
+	return fieldErrors(problems...)
+}
+
+// fieldErrors reports invalid request fields as a validation error, or
+// returns nil if there are none.
+func fieldErrors(problems ...FieldError) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	err := newError(ErrorKindValidation, "validation_failed", "invalid request: %s %s", problems[0].Field, problems[0].Reason)
+	if len(problems) > 1 {
+		err.Message += fmt.Sprintf(" (and %d more)", len(problems)-1)
+	}
+	err.Details = problems
+	return err
+}
+
+func validateStruct(v reflect.Value, prefix string, problems *[]FieldError) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			validateStruct(value, prefix, problems)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		path := prefix + name
+
+		// Tags were checked at startup by checkValidationRules
+		rules, _ := parseValidationRules(field.Tag.Get("validate"))
+		for _, rule := range rules {
+			if reason := checkRule(rule, value, v); reason != "" {
+				*problems = append(*problems, FieldError{Field: path, Reason: reason})
+				break
+			}
+		}
+
+		// Recurse into nested structs
+		nested := value
+		for nested.Kind() == reflect.Pointer && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && nested.Type() != reflect.TypeOf(time.Time{}) {
+			validateStruct(nested, path+".", problems)
+		}
+	}
+}
+
+// isEmpty reports whether a field holds its zero value, blank strings
+// included.
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
+
+// checkRule returns why value breaks rule, or "" if it does not. parent is
+// the struct holding value, for rules comparing fields.
+func checkRule(rule validationRule, value, parent reflect.Value) string {
+	if rule.name == "required" {
+		if isEmpty(value) {
+			return "is required"
+		}
+		return ""
+	}
+	if isEmpty(value) {
+		return ""
+	}
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+
+	switch rule.name {
+	case "min", "max":
+		bound, _ := strconv.ParseFloat(rule.arg, 64)
+		var n float64
+		unit := ""
+		switch value.Kind() {
+		case reflect.String:
+			n, unit = float64(len([]rune(value.String()))), " characters"
+		case reflect.Slice, reflect.Map:
+			n, unit = float64(value.Len()), " items"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(value.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = value.Float()
+		default:
+			return ""
+		}
+		if rule.name == "min" && n < bound {
+			return fmt.Sprintf("must be at least %s%s", rule.arg, unit)
+		}
+		if rule.name == "max" && n > bound {
+			return fmt.Sprintf("must be at most %s%s", rule.arg, unit)
+		}
+	case "oneof":
+		allowed := strings.Fields(rule.arg)
+		for _, option := range allowed {
+			if fmt.Sprint(value.Interface()) == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(allowed, ", ")
+	case "after":
+		other := fieldByJSONName(parent, rule.arg)
+		if !other.IsValid() || isEmpty(other) {
+			return ""
+		}
+		if !value.Interface().(time.Time).After(other.Interface().(time.Time)) {
+			return "must be after " + rule.arg
+		}
+	}
+	return ""
+}
+
+// fieldByJSONName finds the field of struct v with the given JSON name,
+// looking into embedded structs.
+func fieldByJSONName(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if found := fieldByJSONName(v.Field(i), name); found.IsValid() {
+				return found
+			}
+			continue
+		}
+		if tagName, _, _ := strings.Cut(field.Tag.Get("json"), ","); tagName == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testValidationAddress struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"min=4,max=5"`
+}
+
+type testValidationPaging struct {
+	Limit int    `json:"limit" validate:"max=100"`
+	Order string `json:"order" validate:"oneof=asc desc"`
+}
+
+type testValidationRequest struct {
+	testValidationPaging
+	Name     string                 `json:"name" validate:"required,max=5"`
+	Role     string                 `json:"role" validate:"oneof=customer merchant"`
+	Price    float32                `json:"price" validate:"min=0"`
+	Count    *int                   `json:"count" validate:"required,min=1"`
+	Tags     []string               `json:"tags" validate:"max=2"`
+	Start    time.Time              `json:"start" validate:"required"`
+	End      time.Time              `json:"end" validate:"after=start"`
+	Address  testValidationAddress  `json:"address"`
+	Previous *testValidationAddress `json:"previous"`
+	Ignored  string                 `json:"-" validate:"required"`
+	internal string                 `validate:"required"`
+}
+
+func validTestRequest() testValidationRequest {
+	count := 1
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return testValidationRequest{
+		Name:    "alice",
+		Count:   &count,
+		Start:   start,
+		End:     start.AddDate(0, 1, 0),
+		Address: testValidationAddress{City: "Berlin", Zip: "10115"},
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	zero, three := 0, 3
+	tests := []struct {
+		name   string
+		modify func(r *testValidationRequest)
+		want   []FieldError
+	}{
+		{name: "valid", modify: func(r *testValidationRequest) {}},
+		{name: "optional fields set", modify: func(r *testValidationRequest) {
+			r.Role = "merchant"
+			r.Tags = []string{"a", "b"}
+			r.Count = &three
+			r.Previous = &testValidationAddress{City: "Hamburg"}
+		}},
+		{name: "missing required string", modify: func(r *testValidationRequest) { r.Name = "" },
+			want: []FieldError{{Field: "name", Reason: "is required"}}},
+		{name: "blank required string", modify: func(r *testValidationRequest) { r.Name = "  " },
+			want: []FieldError{{Field: "name", Reason: "is required"}}},
+		{name: "nil required pointer", modify: func(r *testValidationRequest) { r.Count = nil },
+			want: []FieldError{{Field: "count", Reason: "is required"}}},
+		{name: "zero behind required pointer", modify: func(r *testValidationRequest) { r.Count = &zero },
+			want: []FieldError{{Field: "count", Reason: "must be at least 1"}}},
+		{name: "missing required time", modify: func(r *testValidationRequest) { r.Start = time.Time{} },
+			want: []FieldError{{Field: "start", Reason: "is required"}}},
+		{name: "string too long", modify: func(r *testValidationRequest) { r.Name = "alexandra" },
+			want: []FieldError{{Field: "name", Reason: "must be at most 5 characters"}}},
+		{name: "length counts characters", modify: func(r *testValidationRequest) { r.Name = "zoë ö" }},
+		{name: "negative number", modify: func(r *testValidationRequest) { r.Price = -1 },
+			want: []FieldError{{Field: "price", Reason: "must be at least 0"}}},
+		{name: "too many items", modify: func(r *testValidationRequest) { r.Tags = []string{"a", "b", "c"} },
+			want: []FieldError{{Field: "tags", Reason: "must be at most 2 items"}}},
+		{name: "not one of", modify: func(r *testValidationRequest) { r.Role = "admin" },
+			want: []FieldError{{Field: "role", Reason: "must be one of customer, merchant"}}},
+		{name: "end before start", modify: func(r *testValidationRequest) { r.End = r.Start.AddDate(0, 0, -1) },
+			want: []FieldError{{Field: "end", Reason: "must be after start"}}},
+		{name: "end equal to start", modify: func(r *testValidationRequest) { r.End = r.Start },
+			want: []FieldError{{Field: "end", Reason: "must be after start"}}},
+		{name: "after skipped without other field", modify: func(r *testValidationRequest) {
+			r.Start = time.Time{}
+			r.End = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		}, want: []FieldError{{Field: "start", Reason: "is required"}}},
+		{name: "nested struct", modify: func(r *testValidationRequest) { r.Address = testValidationAddress{Zip: "123"} },
+			want: []FieldError{{Field: "address.city", Reason: "is required"}, {Field: "address.zip", Reason: "must be at least 4 characters"}}},
+		{name: "nested pointer", modify: func(r *testValidationRequest) { r.Previous = &testValidationAddress{Zip: "123456"} },
+			want: []FieldError{{Field: "previous.city", Reason: "is required"}, {Field: "previous.zip", Reason: "must be at most 5 characters"}}},
+		{name: "embedded struct", modify: func(r *testValidationRequest) {
+			r.Limit = 101
+			r.Order = "random"
+		}, want: []FieldError{{Field: "limit", Reason: "must be at most 100"}, {Field: "order", Reason: "must be one of asc, desc"}}},
+		{name: "every problem reported", modify: func(r *testValidationRequest) {
+			r.Name = ""
+			r.Price = -1
+			r.Role = "admin"
+		}, want: []FieldError{{Field: "name", Reason: "is required"}, {Field: "role", Reason: "must be one of customer, merchant"}, {Field: "price", Reason: "must be at least 0"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := validTestRequest()
+			tt.modify(&request)
+
+			err := validateRequest(&request)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateRequest: %v", err)
+				}
+				return
+			}
+
+			domainErr, ok := err.(*Error)
+			if !ok || domainErr.Kind != ErrorKindValidation || domainErr.Code != "validation_failed" {
+				t.Fatalf("error = %v, want a validation_failed error", err)
+			}
+			if !slices.Equal(domainErr.Details, tt.want) {
+				t.Errorf("details = %v, want %v", domainErr.Details, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckValidationRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		request interface{}
+		wantErr bool
+	}{
+		{name: "valid", request: testValidationRequest{}},
+		{name: "unknown rule", request: struct {
+			Name string `json:"name" validate:"requird"`
+		}{}, wantErr: true},
+		{name: "bound is not a number", request: struct {
+			Name string `json:"name" validate:"max=ten"`
+		}{}, wantErr: true},
+		{name: "missing argument", request: struct {
+			Role string `json:"role" validate:"oneof"`
+		}{}, wantErr: true},
+		{name: "after names no field", request: struct {
+			End time.Time `json:"end" validate:"after=begin"`
+		}{}, wantErr: true},
+		{name: "after names no time", request: struct {
+			Start string    `json:"start"`
+			End   time.Time `json:"end" validate:"after=start"`
+		}{}, wantErr: true},
+		{name: "invalid nested rule", request: struct {
+			Items []struct {
+				Price float32 `json:"price" validate:"minimum=0"`
+			} `json:"items"`
+		}{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkValidationRules(reflect.TypeOf(tt.request))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkValidationRules error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}